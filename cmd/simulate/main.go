@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/xalbd/blackjack-server/game"
+)
+
+func main() {
+	configPath := flag.String("config", os.Getenv("BLACKJACK_CONFIG"), "path to JSON config file the room is taken from")
+	room := flag.String("room", "roomy", "room whose rules are simulated")
+	decks := flag.Int("decks", 0, "number of decks, overriding the room")
+	penetration := flag.Float64("penetration", 0, "share of the shoe dealt before reshuffling, overriding the room")
+	h17 := flag.Bool("h17", false, "dealer hits soft 17, overriding the room")
+	das := flag.Bool("das", false, "double after split, overriding the room")
+	surrender := flag.Bool("surrender", false, "late surrender, overriding the room")
+	blackjackPays := flag.String("blackjack-pays", "", "3:2 or 6:5, overriding the room")
+	rounds := flag.Int64("rounds", 1000000, "rounds to play")
+	workers := flag.Int("workers", runtime.NumCPU(), "tables played in parallel")
+	strategy := flag.String("strategy", "basic", "basic, mimic or neverBust")
+	spread := flag.String("spread", "1", "comma separated bets in minimum bets by Hi-Lo true count from 0 up")
+	bankroll := flag.Int64("bankroll", 100, "bankroll in minimum bets for risk of ruin")
+	session := flag.Int64("session", 1000, "rounds in a session")
+	out := flag.String("out", "", "file the JSON results are written to, stdout if empty")
+	flag.Parse()
+
+	config, err := game.LoadConfig(*configPath)
+	if err != nil {
+		log.Fatalln("error loading config:", err)
+	}
+
+	table, ok := config.Rooms[*room]
+	if !ok {
+		log.Fatalln("no room named", *room)
+	}
+
+	// only flags that were given override the room
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "decks":
+			table.Decks = *decks
+		case "penetration":
+			table.Penetration = *penetration
+		case "h17":
+			table.Rules.DealerHitsSoft17 = *h17
+		case "das":
+			table.Rules.DoubleAfterSplit = *das
+		case "surrender":
+			table.Rules.Surrender = *surrender
+		case "blackjack-pays":
+			table.Rules.BlackjackPays = *blackjackPays
+		}
+	})
+
+	var units []int64
+	for _, s := range strings.Split(*spread, ",") {
+		u, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+		if err != nil {
+			log.Fatalln("spread must be comma separated whole numbers")
+		}
+		units = append(units, u)
+	}
+
+	result, err := game.Simulate(game.SimulationConfig{
+		Table:    table,
+		Rounds:   *rounds,
+		Workers:  *workers,
+		Strategy: *strategy,
+		Spread:   units,
+		Bankroll: *bankroll,
+		Session:  *session,
+	})
+	if err != nil {
+		log.Fatalln("invalid simulation:", err)
+	}
+
+	w := os.Stdout
+	if *out != "" {
+		w, err = os.Create(*out)
+		if err != nil {
+			log.Fatalln("error creating output:", err)
+		}
+		defer w.Close()
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	err = encoder.Encode(result)
+	if err != nil {
+		log.Fatalln("error writing results:", err)
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+
+	"github.com/xalbd/blackjack-server/game"
+)
+
+func main() {
+	path := flag.String("file", os.Getenv("BLACKJACK_LEDGER_FILE"), "ledger file to check")
+	key := flag.String("key", "", "HMAC key the ledger was chained with, defaults to BLACKJACK_LEDGER_KEY")
+	head := flag.String("head", "", "hash the last entry should have, to catch entries cut off the end")
+	flag.Parse()
+
+	if *key == "" {
+		*key = os.Getenv("BLACKJACK_LEDGER_KEY")
+	}
+	if *path == "" {
+		log.Fatalln("no ledger file given")
+	}
+
+	f, err := os.Open(*path)
+	if err != nil {
+		log.Fatalln("error opening ledger:", err)
+	}
+	defer f.Close()
+
+	report, err := game.VerifyLedger(f, []byte(*key))
+	if err != nil {
+		log.Fatalln("error reading ledger:", err)
+	}
+	if *head != "" && report.Head != *head {
+		report.Problems = append(report.Problems, game.LedgerProblem{Line: report.Entries, Problem: "last entry is not the expected head, entries are missing from the end"})
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(report)
+
+	if len(report.Problems) > 0 {
+		os.Exit(1)
+	}
+}
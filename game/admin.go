@@ -0,0 +1,475 @@
+package game
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// one admin call, kept whether or not it was allowed
+type adminEntry struct {
+	Time   time.Time       `json:"time"`
+	Method string          `json:"method"`
+	Path   string          `json:"path"`
+	Room   string          `json:"room,omitempty"`
+	UID    string          `json:"playerId,omitempty"`
+	Remote string          `json:"remote"`
+	Status int             `json:"status"`
+	Body   json.RawMessage `json:"body,omitempty"`
+}
+
+type adminAudit interface {
+	add(e adminEntry) error
+	// every entry, oldest first
+	list() ([]adminEntry, error)
+}
+
+type memoryAudit struct {
+	entries []adminEntry
+	lock    sync.RWMutex
+}
+
+func (a *memoryAudit) add(e adminEntry) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	a.entries = append(a.entries, e)
+	return nil
+}
+
+func (a *memoryAudit) list() ([]adminEntry, error) {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+
+	return slices.Clone(a.entries), nil
+}
+
+// appends every admin call to a JSON lines file
+type fileAudit struct {
+	memoryAudit
+	file *os.File
+}
+
+func newFileAudit(path string) (*fileAudit, error) {
+	f, entries, err := openJSONLines[adminEntry](path)
+	if err != nil {
+		return nil, err
+	}
+	return &fileAudit{memoryAudit{entries: entries}, f}, nil
+}
+
+func (a *fileAudit) add(e adminEntry) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	err := appendJSONLines(a.file, e)
+	if err != nil {
+		return err
+	}
+	a.entries = append(a.entries, e)
+	return nil
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// largest admin request body, in bytes
+const maxAdminBody = 1 << 20
+
+// checks the admin token and writes the call to the audit log
+func (server *server) admin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !server.allowAuth(w, r) {
+			return
+		}
+
+		rec := &statusRecorder{w, http.StatusOK}
+		e := adminEntry{time.Now(), r.Method, r.URL.Path, r.PathValue("room"), r.PathValue("uid"), r.RemoteAddr, 0, nil}
+		if server.isAdmin(r) {
+			// one byte past the limit tells a body that fits from one that was cut off
+			body, err := io.ReadAll(io.LimitReader(r.Body, maxAdminBody+1))
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, errorResponse{err.Error()})
+				return
+			}
+			if len(body) > maxAdminBody {
+				writeJSON(rec, http.StatusRequestEntityTooLarge, errorResponse{"body too large"})
+			} else {
+				r.Body = io.NopCloser(bytes.NewReader(body))
+				if json.Valid(body) {
+					e.Body = body
+				}
+				next(rec, r)
+			}
+		} else {
+			// rejected calls are logged without their body, anyone can make them
+			authFailuresCounter.WithLabelValues("admin").Inc()
+			writeJSON(rec, http.StatusUnauthorized, errorResponse{"admin token required"})
+		}
+
+		e.Status = rec.status
+		err := server.audit.add(e)
+		if err != nil {
+			slog.Error("error writing admin audit log", "method", e.Method, "path", e.Path, "err", err)
+		}
+	}
+}
+
+func (server *server) handleAdminAuditRequest(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		page, pageSize, err := parsePage(r.URL.Query())
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, errorResponse{err.Error()})
+			return
+		}
+
+		entries, err := server.audit.list()
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, errorResponse{err.Error()})
+			return
+		}
+		slices.Reverse(entries)
+
+		start := min((page-1)*pageSize, len(entries))
+		end := min(start+pageSize, len(entries))
+		writeJSON(w, http.StatusOK, auditResponse{entries[start:end], page, pageSize, len(entries)})
+	}
+}
+
+type auditResponse struct {
+	Entries  []adminEntry `json:"entries"` // newest first
+	Page     int          `json:"page"`
+	PageSize int          `json:"pageSize"`
+	Total    int          `json:"total"`
+}
+
+// everything about a room as its table sees it
+type roomState struct {
+	Code     string        `json:"code"`
+	Training bool          `json:"training"`
+	Creator  string        `json:"creator,omitempty"`
+	Clients  []string      `json:"clients"` // uid of every connection
+	Table    tableSnapshot `json:"table"`
+}
+
+// everything about the room; false if the table has stopped
+func (room *room) state() (roomState, bool) {
+	t, ok := room.tableState()
+	if !ok {
+		return roomState{}, false
+	}
+
+	s := roomState{Code: room.code, Training: room.training, Creator: room.creator, Clients: []string{}, Table: t}
+	room.clientLock.Lock()
+	for _, uid := range room.clients {
+		s.Clients = append(s.Clients, uid)
+	}
+	room.clientLock.Unlock()
+	slices.Sort(s.Clients)
+	return s, true
+}
+
+func (server *server) handleAdminRoomsRequest(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		states := []roomState{}
+		for _, room := range server.allRooms() {
+			if s, ok := room.state(); ok {
+				states = append(states, s)
+			}
+		}
+		slices.SortFunc(states, func(a, b roomState) int {
+			return strings.Compare(a.Code, b.Code)
+		})
+		writeJSON(w, http.StatusOK, states)
+	}
+}
+
+// refunds any bets still out, disconnects everyone and removes the room and its snapshot;
+// with emptyFor set, only if nobody has been connected for that long
+func (server *server) closeRoom(code string, reason string, emptyFor time.Duration) bool {
+	server.roomLock.Lock()
+	room, ok := server.rooms[code]
+	ok = ok && room.startClosing(emptyFor)
+	if ok {
+		delete(server.rooms, code)
+	}
+	server.roomLock.Unlock()
+	if !ok {
+		return false
+	}
+
+	select {
+	case room.drain <- struct{}{}:
+	case <-room.drained:
+	}
+	select {
+	case room.abort <- struct{}{}:
+	case <-room.drained:
+	}
+	<-room.drained
+
+	if room.snapshots != nil {
+		err := room.snapshots.remove(code)
+		if err != nil {
+			room.log.Error("error removing snapshot", "err", err)
+		}
+	}
+	room.closeClients(websocket.CloseNormalClosure, reason)
+	roomsGauge.Dec()
+	forgetRoomMetrics(code)
+	server.boards.forgetRoom(code)
+	room.log.Info("closed room", "reason", reason)
+	return true
+}
+
+type adminReasonRequest struct {
+	Reason string `json:"reason"`
+}
+
+// reads a request body that has to give a reason
+func decodeReason(r *http.Request) (string, error) {
+	var req adminReasonRequest
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&req)
+	if err != nil {
+		return "", err
+	}
+	if strings.TrimSpace(req.Reason) == "" {
+		return "", errors.New("a reason is required")
+	}
+	return req.Reason, nil
+}
+
+func (server *server) handleAdminCloseRequest(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		reason, err := decodeReason(r)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, errorResponse{err.Error()})
+			return
+		}
+
+		if !server.closeRoom(r.PathValue("room"), reason, 0) {
+			writeJSON(w, http.StatusNotFound, errorResponse{"room not found"})
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// changes a room's configuration between events; settings left out of the body keep their current value
+func (server *server) handleAdminConfigRequest(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		room, ok := server.room(r.PathValue("room"))
+		if !ok {
+			writeJSON(w, http.StatusNotFound, errorResponse{"room not found"})
+			return
+		}
+		s, ok := room.state()
+		if !ok {
+			writeJSON(w, http.StatusNotFound, errorResponse{"room not found"})
+			return
+		}
+
+		// decode over the current settings rather than the defaults
+		type plain tableConfig
+		config := plain(s.Table.Config)
+		decoder := json.NewDecoder(r.Body)
+		decoder.DisallowUnknownFields()
+		err := decoder.Decode(&config)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, errorResponse{err.Error()})
+			return
+		}
+
+		c := tableConfig(config)
+		switch err := c.validate(); {
+		case err != nil:
+			writeJSON(w, http.StatusBadRequest, errorResponse{err.Error()})
+			return
+		case c.Seats != s.Table.Config.Seats || c.Decks != s.Table.Config.Decks || c.Training != s.Table.Config.Training:
+			writeJSON(w, http.StatusBadRequest, errorResponse{"seats, decks and training can't change on a running table"})
+			return
+		}
+
+		select {
+		case room.configs <- c:
+		case <-room.drained:
+			writeJSON(w, http.StatusNotFound, errorResponse{"room not found"})
+			return
+		}
+		writeJSON(w, http.StatusOK, c)
+	}
+}
+
+type ban struct {
+	Time   time.Time `json:"time"`
+	Reason string    `json:"reason"`
+}
+
+func (server *server) isBanned(uid string) bool {
+	server.banLock.RLock()
+	defer server.banLock.RUnlock()
+
+	_, ok := server.bans[uid]
+	return ok
+}
+
+// rebuilds bans from the audit log
+func (server *server) loadBans() error {
+	entries, err := server.audit.list()
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		if e.UID == "" || e.Status != http.StatusOK || !strings.HasSuffix(e.Path, "/ban") {
+			continue
+		}
+
+		switch e.Method {
+		case http.MethodPost:
+			var req adminReasonRequest
+			json.Unmarshal(e.Body, &req)
+			server.bans[e.UID] = ban{e.Time, req.Reason}
+		case http.MethodDelete:
+			delete(server.bans, e.UID)
+		}
+	}
+	return nil
+}
+
+// disconnects every connection the player has in any room
+func (server *server) kick(uid string, reason string) int {
+	server.roomLock.RLock()
+	defer server.roomLock.RUnlock()
+
+	kicked := 0
+	message := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, reason)
+	for _, room := range server.rooms {
+		room.clientLock.Lock()
+		for c, v := range room.clients {
+			if v == uid {
+				c.WriteControl(websocket.CloseMessage, message, time.Now().Add(time.Second))
+				c.Close()
+				kicked++
+			}
+		}
+		room.clientLock.Unlock()
+	}
+	return kicked
+}
+
+type kickResponse struct {
+	Connections int `json:"connections"` // connections closed
+}
+
+func (server *server) handleAdminKickRequest(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		reason, err := decodeReason(r)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, errorResponse{err.Error()})
+			return
+		}
+
+		uid := r.PathValue("uid")
+		n := server.kick(uid, reason)
+		slog.Info("kicked player", "uid", uid, "connections", n, "reason", reason)
+		writeJSON(w, http.StatusOK, kickResponse{n})
+	}
+}
+
+func (server *server) handleAdminBanRequest(w http.ResponseWriter, r *http.Request) {
+	uid := r.PathValue("uid")
+
+	switch r.Method {
+	case http.MethodPost:
+		reason, err := decodeReason(r)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, errorResponse{err.Error()})
+			return
+		}
+
+		b := ban{time.Now(), reason}
+		server.banLock.Lock()
+		server.bans[uid] = b
+		server.banLock.Unlock()
+
+		server.kick(uid, "banned: "+reason)
+		slog.Info("banned player", "uid", uid, "reason", reason)
+		writeJSON(w, http.StatusOK, b)
+	case http.MethodDelete:
+		server.banLock.Lock()
+		_, ok := server.bans[uid]
+		delete(server.bans, uid)
+		server.banLock.Unlock()
+
+		if !ok {
+			writeJSON(w, http.StatusNotFound, errorResponse{"player is not banned"})
+			return
+		}
+		slog.Info("lifted ban", "uid", uid)
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// sent to every connection in every room
+type announcement struct {
+	Message string    `json:"message"`
+	Time    time.Time `json:"time"`
+}
+
+type announcementMessage struct {
+	Announcement announcement `json:"announcement"`
+}
+
+func (server *server) handleAdminAnnounceRequest(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		var req announcement
+		decoder := json.NewDecoder(r.Body)
+		decoder.DisallowUnknownFields()
+		err := decoder.Decode(&req)
+		if err != nil || strings.TrimSpace(req.Message) == "" {
+			writeJSON(w, http.StatusBadRequest, errorResponse{"a message is required"})
+			return
+		}
+		req.Time = time.Now()
+		out, _ := json.Marshal(announcementMessage{req})
+
+		// rooms stopped by a shutdown stay in the map, but nothing reads their broadcasts anymore
+		for _, room := range server.allRooms() {
+			room.debug.broadcastsWaiting.Add(1)
+			select {
+			case room.broadcast <- out:
+			case <-room.drained:
+			}
+			room.debug.broadcastsWaiting.Add(-1)
+		}
+
+		slog.Info("announced", "message", req.Message)
+		writeJSON(w, http.StatusOK, req)
+	}
+}
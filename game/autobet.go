@@ -0,0 +1,94 @@
+package game
+
+// places the seat's bet from last round again
+func (t *table) rebet(uid string, seat int) {
+	if seat < 0 || seat >= t.seats || t.Hands[seat].LastBet == 0 {
+		return
+	}
+
+	t.enterBet(uid, t.Hands[seat].LastBet, seat)
+}
+
+// rebets and returns whether the round can be dealt right away (nobody else is still deciding on a bet)
+func (t *table) rebetAndDeal(uid string, seat int) bool {
+	t.rebet(uid, seat)
+	if seat < 0 || seat >= t.seats || t.Hands[seat].PlayerUID != uid || t.Hands[seat].Bet == 0 {
+		return false
+	}
+
+	for i := range t.Hands {
+		h := t.Hands[i]
+		if h.PlayerUID != "" && h.PlayerUID != uid && !h.SittingOut && h.Bet == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (t *table) setAutoBet(uid string, seat int, enabled bool, stopLosses int, floor int64) {
+	if seat < 0 || seat >= t.seats || t.Hands[seat].PlayerUID != uid || stopLosses < 0 || floor < 0 {
+		return
+	}
+
+	h := &t.Hands[seat]
+	h.AutoBet = enabled
+	h.autoBetStopLosses = stopLosses
+	h.autoBetFloor = floor
+	h.lossStreak = 0
+	t.broadcast()
+}
+
+// tallies each seat's result for the round (split hands follow the seat they came from)
+// and switches off auto-bet for seats that hit their loss limit
+func (t *table) updateLossStreaks() {
+	var seat *Hand
+	var net int64
+	played := false
+
+	finish := func() {
+		if seat == nil || !played {
+			return
+		}
+
+		if net < 0 {
+			seat.lossStreak++
+		} else if net > 0 {
+			seat.lossStreak = 0
+		}
+
+		if seat.AutoBet && seat.autoBetStopLosses > 0 && seat.lossStreak >= seat.autoBetStopLosses {
+			seat.AutoBet = false
+		}
+	}
+
+	for i := range t.Hands {
+		if !t.Hands[i].Split {
+			finish()
+			seat, net, played = &t.Hands[i], 0, false
+		}
+
+		net += t.Hands[i].net
+		played = played || len(t.Hands[i].Cards) > 0
+	}
+	finish()
+}
+
+// places last round's bet for every seat with auto-bet enabled
+func (t *table) placeAutoBets() {
+	for i := range t.Hands {
+		h := &t.Hands[i]
+		if h.PlayerUID == "" || !h.AutoBet || h.SittingOut || h.LastBet == 0 {
+			continue
+		}
+
+		money := t.getMoney(h.PlayerUID)
+		if money < h.autoBetFloor || money < h.LastBet {
+			h.AutoBet = false
+			t.broadcast()
+			continue
+		}
+
+		t.record(roundEvent{Type: EventAutoBet, UID: h.PlayerUID, Hand: i, Bet: h.LastBet})
+		t.enterBet(h.PlayerUID, h.LastBet, i)
+	}
+}
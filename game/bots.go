@@ -0,0 +1,151 @@
+package game
+
+import (
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type botPersonality string
+
+const (
+	BotBasic    botPersonality = "basic"    // flat bets, basic strategy
+	BotCounter  botPersonality = "counter"  // counts cards and spreads bets with the count, basic strategy
+	BotReckless botPersonality = "reckless" // big random bets, hits and doubles far too often
+)
+
+var botNames = []string{"Ada", "Basil", "Cleo", "Dex", "Edie", "Finn", "Gus", "Hazel"}
+
+// bots get uids no auth provider hands out
+func botUID(i int) string {
+	return "bot:" + strconv.Itoa(i+1)
+}
+
+func isBot(uid string) bool {
+	return strings.HasPrefix(uid, "bot:")
+}
+
+// bankroll a bot starts with and is topped back up to when it can't cover the minimum bet
+func (t *table) botBankroll() int64 {
+	return 100 * t.minBet
+}
+
+func (t *table) seatOf(uid string) int {
+	for i, h := range t.Hands {
+		if h.PlayerUID == uid && !h.Split {
+			return i
+		}
+	}
+	return -1
+}
+
+// picks the next thing a bot should do at the table, if anything; bots act through the same commands as humans
+func (t *table) nextBotEvent() (roundEvent, bool) {
+	if len(t.bots) == 0 {
+		return roundEvent{}, false
+	}
+
+	humans, waiting := 0, false
+	for _, p := range t.Players {
+		if p.active && !isBot(p.UID) {
+			humans++
+			waiting = waiting || t.seatOf(p.UID) < 0
+		}
+	}
+	free := t.seats - t.seatsTaken()
+	yield := t.botsYield && waiting
+
+	for i, personality := range t.bots {
+		uid := botUID(i)
+		p := t.playerWithUID(uid)
+		if p == nil || !p.active {
+			if t.status == Betting && !t.draining && free > 0 && !yield {
+				name := botNames[i%len(botNames)] + " (bot)"
+				return roundEvent{Type: EventConnect, UID: uid, DisplayName: name, Money: t.getMoney(uid)}, true
+			}
+			continue
+		}
+
+		seat := t.seatOf(uid)
+		switch t.status {
+		case Betting:
+			if seat < 0 {
+				if free > 0 && !yield && !t.draining {
+					return botCommand(uid, playerCommand{Action: "join", Seat: t.seatOf("")}), true
+				}
+				continue
+			}
+
+			h := t.Hands[seat]
+			switch {
+			case h.Bet > 0 || t.draining:
+			case yield && free == 0:
+				return botCommand(uid, playerCommand{Action: "leave", Seat: seat}), true
+			case humans == 0:
+				// nobody to play for
+			case t.getMoney(uid) < t.minBet:
+				return roundEvent{Type: EventGrant, UID: uid, Money: t.botBankroll() - t.getMoney(uid)}, true
+			case h.SittingOut:
+				return botCommand(uid, playerCommand{Action: "sitIn", Seat: seat}), true
+			default:
+				return botCommand(uid, playerCommand{Action: "bet", Seat: seat, Bet: t.botBet(personality, uid)}), true
+			}
+		case PlayerTurn:
+			if t.Hands[t.ActiveHand].PlayerUID == uid {
+				return botCommand(uid, playerCommand{Action: string(t.botMove(personality))}), true
+			}
+		}
+	}
+	return roundEvent{}, false
+}
+
+func botCommand(uid string, cmd playerCommand) roundEvent {
+	return roundEvent{Type: EventCommand, UID: uid, Command: &cmd}
+}
+
+// how long a bot takes before its next event, like a person thinking it over
+func (t *table) botDelay() time.Duration {
+	if t.status == PlayerTurn {
+		longest := min(2500*time.Millisecond, t.moveTimeLimit/2)
+		return 700*time.Millisecond + time.Duration(rand.Int63n(int64(longest-700*time.Millisecond)))
+	}
+	return 300*time.Millisecond + time.Duration(rand.Int63n(int64(1200*time.Millisecond)))
+}
+
+func (t *table) botBet(personality botPersonality, uid string) int64 {
+	units := int64(2)
+	switch personality {
+	case BotCounter:
+		units = 1
+		for tc := 2.0; tc <= t.trueCount() && units < 8; tc++ {
+			units *= 2
+		}
+	case BotReckless:
+		units = 1 + rand.Int63n(20)
+	}
+
+	bet := min(units*t.minBet, t.getMoney(uid))
+	if t.maxBet > 0 {
+		bet = min(bet, t.maxBet)
+	}
+	return bet
+}
+
+func (t *table) botMove(personality botPersonality) move {
+	if personality != BotReckless {
+		return t.recommendedMove()
+	}
+
+	h := t.currentHand()
+	total := h.bestScore()
+	switch {
+	case t.canSplit():
+		return Split
+	case len(h.Cards) == 2 && t.canDouble() && (total >= 9 && total <= 11 || rand.Intn(5) == 0):
+		return Double
+	case total < 17 || total == 17 && rand.Intn(4) == 0:
+		return Hit
+	}
+	return Stand
+}
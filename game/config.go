@@ -0,0 +1,158 @@
+package game
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+type tableRules struct {
+	DealerHitsSoft17 bool   `json:"dealerHitsSoft17"`
+	BlackjackPays    string `json:"blackjackPays"` // "3:2" or "6:5"
+	DoubleAfterSplit bool   `json:"doubleAfterSplit"`
+	Surrender        bool   `json:"surrender"` // late surrender
+}
+
+// full configuration of a table, times are in milliseconds
+type tableConfig struct {
+	Seats            int              `json:"seats"`
+	Decks            int              `json:"decks"`
+	Penetration      float64          `json:"penetration"` // share of the shoe dealt before reshuffling between rounds, 0 deals it all
+	MinBet           int64            `json:"minBet"`
+	MaxBet           int64            `json:"maxBet"` // 0 for no limit
+	MoveTimeLimit    int64            `json:"moveTimeLimit"`
+	BettingTimeLimit int64            `json:"bettingTimeLimit"`
+	TimeBank         int64            `json:"timeBank"`
+	MaxMissedRounds  int              `json:"maxMissedRounds"`
+	Timeout          timeoutAction    `json:"timeout"`
+	Hints            bool             `json:"hints"`           // players can ask for the basic strategy play on their turn
+	Training         bool             `json:"training"`        // grade every play against basic strategy, using play money
+	Bots             []botPersonality `json:"bots"`            // computer players filling seats, one per entry
+	BotsYield        bool             `json:"botsYield"`       // bots give up their seat when a human is waiting for one
+	Countermeasures  countermeasures  `json:"countermeasures"` // against players flagged for counting cards
+	Rules            tableRules       `json:"rules"`
+}
+
+func defaultTableConfig() tableConfig {
+	return tableConfig{
+		Seats:            6,
+		Decks:            1,
+		MinBet:           10,
+		MoveTimeLimit:    5000,
+		BettingTimeLimit: 15000,
+		TimeBank:         30000,
+		MaxMissedRounds:  3,
+		Timeout:          TimeoutStand,
+		Hints:            true,
+		Rules: tableRules{
+			BlackjackPays:    "3:2",
+			DoubleAfterSplit: true,
+		},
+	}
+}
+
+// settings left out of the document keep their defaults
+func (c *tableConfig) UnmarshalJSON(data []byte) error {
+	type plain tableConfig
+	p := plain(defaultTableConfig())
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&p)
+	if err != nil {
+		return err
+	}
+
+	*c = tableConfig(p)
+	return nil
+}
+
+// checks that every setting is in range
+func (c tableConfig) validate() error {
+	switch {
+	case c.Seats < 2 || c.Seats > 8:
+		return errors.New("seats must be between 2 and 8")
+	case c.Decks < 1 || c.Decks > 8:
+		return errors.New("decks must be between 1 and 8")
+	case c.Penetration < 0 || c.Penetration > 1:
+		return errors.New("penetration must be between 0 and 1")
+	case c.MinBet < 1:
+		return errors.New("minBet must be positive")
+	case c.MaxBet != 0 && c.MaxBet < c.MinBet:
+		return errors.New("maxBet must be 0 or at least minBet")
+	case c.MoveTimeLimit < 2000 || c.MoveTimeLimit > 60000:
+		return errors.New("moveTimeLimit must be between 2000 and 60000")
+	case c.BettingTimeLimit < 5000 || c.BettingTimeLimit > 120000:
+		return errors.New("bettingTimeLimit must be between 5000 and 120000")
+	case c.TimeBank < 0 || c.TimeBank > 300000:
+		return errors.New("timeBank must be between 0 and 300000")
+	case c.MaxMissedRounds < 1 || c.MaxMissedRounds > 20:
+		return errors.New("maxMissedRounds must be between 1 and 20")
+	case c.Timeout != TimeoutStand && c.Timeout != TimeoutBasicStrategy && c.Timeout != TimeoutSurrender:
+		return errors.New("timeout must be stand, basicStrategy or surrender")
+	case c.Timeout == TimeoutSurrender && !c.Rules.Surrender:
+		return errors.New("timeout surrender requires the surrender rule")
+	case c.Rules.BlackjackPays != "3:2" && c.Rules.BlackjackPays != "6:5":
+		return errors.New("blackjackPays must be 3:2 or 6:5")
+	case c.Countermeasures.BetCap < 0:
+		return errors.New("countermeasures betCap must not be negative")
+	case len(c.Bots) > c.Seats:
+		return errors.New("bots must not outnumber seats")
+	}
+
+	for _, b := range c.Bots {
+		if b != BotBasic && b != BotCounter && b != BotReckless {
+			return errors.New("bots must be basic, counter or reckless")
+		}
+	}
+
+	return nil
+}
+
+// total returned for a winning blackjack, including the original bet
+func (r tableRules) blackjackPayout(bet int64) int64 {
+	if r.BlackjackPays == "6:5" {
+		return bet + 6*bet/5
+	}
+	return bet + 3*bet/2
+}
+
+// applies a configuration to the table, seats and decks only take effect on a new table
+func (t *table) applyConfig(c tableConfig) {
+	t.minBet = c.MinBet
+	t.penetration = c.Penetration
+	t.maxBet = c.MaxBet
+	t.moveTimeLimit = time.Duration(c.MoveTimeLimit) * time.Millisecond
+	t.bettingTimeLimit = time.Duration(c.BettingTimeLimit) * time.Millisecond
+	t.timeBank = time.Duration(c.TimeBank) * time.Millisecond
+	t.maxMissedRounds = c.MaxMissedRounds
+	t.timeoutAction = c.Timeout
+	t.hints = c.Hints
+	t.training = c.Training
+	t.bots = c.Bots
+	t.botsYield = c.BotsYield
+	t.countermeasures = c.Countermeasures
+	t.rules = c.Rules
+}
+
+func (t *table) config() tableConfig {
+	return tableConfig{
+		Seats:            t.seats,
+		Decks:            t.decks,
+		Penetration:      t.penetration,
+		MinBet:           t.minBet,
+		MaxBet:           t.maxBet,
+		MoveTimeLimit:    t.moveTimeLimit.Milliseconds(),
+		BettingTimeLimit: t.bettingTimeLimit.Milliseconds(),
+		TimeBank:         t.timeBank.Milliseconds(),
+		MaxMissedRounds:  t.maxMissedRounds,
+		Timeout:          t.timeoutAction,
+		Hints:            t.hints,
+		Training:         t.training,
+		Bots:             t.bots,
+		BotsYield:        t.botsYield,
+		Countermeasures:  t.countermeasures,
+		Rules:            t.rules,
+	}
+}
@@ -0,0 +1,47 @@
+package game
+
+// what a table does about players flagged for betting with the count
+type countermeasures struct {
+	Shuffle bool  `json:"shuffle"` // shuffle before the deal when a flagged player has a bet in at a true count of 2 or more
+	BetCap  int64 `json:"betCap"`  // most a flagged player may bet, in minimum bets, 0 for no cap
+}
+
+// Hi-Lo running count over every card dealt since the shuffle; by the time bets are placed all of them are face up
+func (t *table) runningCount() int {
+	running := 0
+	for _, c := range t.deck.cards[:t.deck.index] {
+		switch v := c.value(); {
+		case v >= 2 && v <= 6:
+			running++
+		case v == 10 || v == 1:
+			running--
+		}
+	}
+	return running
+}
+
+// running count per deck left in the shoe
+func (t *table) trueCount() float64 {
+	decksLeft := max(float64(len(t.deck.cards)-t.deck.index)/52, 0.5)
+	return float64(t.runningCount()) / decksLeft
+}
+
+// whether a bet goes over the cap for a flagged player
+func (t *table) overBetCap(uid string, bet int64) bool {
+	p := t.playerWithUID(uid)
+	return p != nil && p.flagged && t.countermeasures.BetCap > 0 && bet > t.countermeasures.BetCap*t.minBet
+}
+
+// shuffles away a good count before a flagged player gets to play it
+func (t *table) shuffleAgainstCounters() {
+	if !t.countermeasures.Shuffle || t.trueCount() < 2 {
+		return
+	}
+
+	for _, h := range t.Hands {
+		if p := t.playerWithUID(h.PlayerUID); p != nil && p.flagged && h.Bet > 0 {
+			t.reshuffle()
+			return
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package game
+
+import (
+	"cmp"
+	"log/slog"
+	"math"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// when players are flagged for betting with the count, 0 window disables it
+type countWatchConfig struct {
+	Window    int     `json:"window"`    // most recent bets per player the correlation is taken over
+	MinBets   int     `json:"minBets"`   // bets needed before a player can be flagged
+	Threshold float64 `json:"threshold"` // correlation between bet and true count at which a player is flagged
+}
+
+// a bet and the count of the shoe when it was placed
+type countSample struct {
+	Bet       int64   `json:"bet"`
+	Running   int     `json:"running"`
+	TrueCount float64 `json:"trueCount"`
+}
+
+type countFlag struct {
+	Time        time.Time `json:"time"`
+	Room        string    `json:"room"`
+	UID         string    `json:"playerId"`
+	Correlation float64   `json:"correlation"`
+	Bets        int       `json:"bets"`
+	Flagged     bool      `json:"flagged"` // false when a flag is lifted
+}
+
+type countWatch struct {
+	config  countWatchConfig
+	samples map[string][]countSample
+	flagged map[string]bool
+	feed    []countFlag // oldest first, at most countFeedSize
+	lock    sync.Mutex
+}
+
+const countFeedSize = 1000
+
+func newCountWatch(config countWatchConfig) *countWatch {
+	return &countWatch{config: config, samples: make(map[string][]countSample), flagged: make(map[string]bool)}
+}
+
+// Pearson correlation between bets and true counts, 0 when either never changes
+func betCountCorrelation(samples []countSample) float64 {
+	n := float64(len(samples))
+	var sx, sy, sxx, syy, sxy float64
+	for _, s := range samples {
+		x, y := float64(s.Bet), s.TrueCount
+		sx += x
+		sy += y
+		sxx += x * x
+		syy += y * y
+		sxy += x * y
+	}
+
+	cov := sxy - sx*sy/n
+	vx, vy := sxx-sx*sx/n, syy-sy*sy/n
+	if vx <= 0 || vy <= 0 {
+		return 0
+	}
+	return cov / math.Sqrt(vx*vy)
+}
+
+// adds a bet to the player's window, returning a flag if they were just flagged or cleared
+func (w *countWatch) observe(room string, uid string, s countSample) (countFlag, bool) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	samples := append(w.samples[uid], s)
+	if len(samples) > w.config.Window {
+		samples = slices.Delete(samples, 0, len(samples)-w.config.Window)
+	}
+	w.samples[uid] = samples
+	if len(samples) < w.config.MinBets {
+		return countFlag{}, false
+	}
+
+	// flags are only lifted well below the threshold, so players near it don't flip back and forth
+	c := betCountCorrelation(samples)
+	flagged := c >= w.config.Threshold || w.flagged[uid] && c >= w.config.Threshold/2
+	if flagged == w.flagged[uid] {
+		return countFlag{}, false
+	}
+
+	w.flagged[uid] = flagged
+	f := countFlag{time.Now(), room, uid, math.Round(c*1000) / 1000, len(samples), flagged}
+	w.feed = append(w.feed, f)
+	if len(w.feed) > countFeedSize {
+		w.feed = slices.Delete(w.feed, 0, len(w.feed)-countFeedSize)
+	}
+	return f, true
+}
+
+func (w *countWatch) isFlagged(uid string) bool {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	return w.flagged[uid]
+}
+
+// called from a table's goroutine for every bet placed
+func (server *server) betPlaced(room string, uid string, s countSample) {
+	if server.counts == nil || isBot(uid) {
+		return
+	}
+
+	f, ok := server.counts.observe(room, uid, s)
+	if !ok {
+		return
+	}
+
+	if f.Flagged {
+		slog.Warn("flagged for betting with the count", "room", room, "uid", uid, "correlation", f.Correlation, "bets", f.Bets)
+	} else {
+		slog.Info("lifted count flag", "room", room, "uid", uid, "correlation", f.Correlation, "bets", f.Bets)
+	}
+
+	// tables find out through an event like any other input, which can't be sent from the table's own goroutine
+	go server.sendFlag(f)
+}
+
+// tells every table the player is at whether they are flagged, so the room's countermeasures apply to them
+func (server *server) sendFlag(f countFlag) {
+	// a table can take a while to get to the flag, so don't hold up rooms being added or closed meanwhile
+	for _, room := range server.allRooms() {
+		if room.training || !room.hasClient(f.UID) {
+			continue
+		}
+		select {
+		case room.flags <- f:
+		case <-room.drained:
+		}
+	}
+}
+
+type watchedPlayer struct {
+	UID         string      `json:"playerId"`
+	Bets        int         `json:"bets"`
+	Correlation float64     `json:"correlation"`
+	Flagged     bool        `json:"flagged"`
+	Last        countSample `json:"last"`
+}
+
+type countFeedResponse struct {
+	Flags   []countFlag     `json:"flags"`   // newest first
+	Players []watchedPlayer `json:"players"` // most correlated first
+}
+
+func (server *server) handleCountFeedRequest(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		if server.counts == nil {
+			writeJSON(w, http.StatusNotFound, errorResponse{"count watching is disabled"})
+			return
+		}
+
+		watch := server.counts
+		watch.lock.Lock()
+		res := countFeedResponse{Flags: append([]countFlag{}, watch.feed...), Players: []watchedPlayer{}}
+		for uid, samples := range watch.samples {
+			c := math.Round(betCountCorrelation(samples)*1000) / 1000
+			res.Players = append(res.Players, watchedPlayer{uid, len(samples), c, watch.flagged[uid], samples[len(samples)-1]})
+		}
+		watch.lock.Unlock()
+
+		slices.Reverse(res.Flags)
+		slices.SortFunc(res.Players, func(a, b watchedPlayer) int {
+			return cmp.Compare(b.Correlation, a.Correlation)
+		})
+		writeJSON(w, http.StatusOK, res)
+	}
+}
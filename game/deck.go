@@ -45,10 +45,21 @@ type Deck struct {
 }
 
 type Hand struct {
-	Cards     []card `json:"cards"`
-	Bet       int64  `json:"bet"`
-	PlayerUID string `json:"playerId"`
-	Split     bool   `json:"split"`
+	Cards        []card `json:"cards"`
+	Bet          int64  `json:"bet"`
+	PlayerUID    string `json:"playerId"`
+	Split        bool   `json:"split"`
+	SittingOut   bool   `json:"sittingOut"`
+	AutoBet      bool   `json:"autoBet"`
+	LastBet      int64  `json:"lastBet"`
+	missedRounds int
+	// auto-bet switches off after this many losses in a row or when money drops below the floor (0 disables)
+	autoBetStopLosses int
+	autoBetFloor      int64
+	lossStreak        int
+	net               int64
+	doubled           bool
+	fromSplit         bool // either half of a split, unlike Split which only marks the added hand
 }
 
 func makeDeck(decks int) Deck {
@@ -152,6 +163,11 @@ func (h *Hand) bestScore() int {
 	return best
 }
 
+// returns whether the best score counts an ace as 11
+func (h *Hand) isSoft() bool {
+	return len(h.scores()) == 2
+}
+
 func (h *Hand) hasBlackjack() bool {
 	return len(h.Cards) == 2 && h.bestScore() == 21
 }
@@ -0,0 +1,382 @@
+package game
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// cards left in a shoe by value, 1 for aces through 10 for tens and faces; index 0 is unused
+type shoeCounts [11]int
+
+func fullShoe(decks int) shoeCounts {
+	var s shoeCounts
+	for v := 1; v <= 9; v++ {
+		s[v] = 4 * decks
+	}
+	s[10] = 16 * decks
+	return s
+}
+
+func (s *shoeCounts) remove(cards ...card) {
+	for _, c := range cards {
+		s[c.value()]--
+	}
+}
+
+func (s shoeCounts) total() int {
+	n := 0
+	for _, c := range s[1:] {
+		n += c
+	}
+	return n
+}
+
+// cards the active player hasn't seen: the rest of the shoe and the dealer's hole card
+func (t *table) unseenCards() shoeCounts {
+	var s shoeCounts
+	for _, c := range t.deck.cards[t.deck.index:] {
+		s[c.value()]++
+	}
+	if len(t.dealer.Cards) > 1 {
+		s[t.dealer.Cards[1].value()]++
+	}
+
+	// the table reshuffles before running out, so a nearly empty shoe plays like a fresh one
+	if s.total() < 20 {
+		s = fullShoe(t.decks)
+		s.remove(t.dealer.Cards[0])
+		for _, h := range t.Hands {
+			s.remove(h.Cards...)
+		}
+	}
+	return s
+}
+
+func handTotal(hard int, aces bool) int {
+	if aces && hard+10 <= 21 {
+		return hard + 10
+	}
+	return hard
+}
+
+func makesBlackjack(a int, b int) bool {
+	return a+b == 11 && (a == 1 || b == 1)
+}
+
+type dealerKey struct {
+	shoe shoeCounts
+	up   int
+	h17  bool
+}
+
+// chance of each final dealer total by total, 0 meaning bust
+type dealerOdds [22]float64
+
+const evCacheSize = 200000
+
+// cleared whenever it grows past evCacheSize entries
+var dealerCache = struct {
+	sync.Mutex
+	odds map[dealerKey]dealerOdds
+}{odds: make(map[dealerKey]dealerOdds)}
+
+// chance of each final dealer total drawing from shoe, given the dealer didn't have blackjack
+func dealerOutcomes(shoe shoeCounts, up int, h17 bool) dealerOdds {
+	key := dealerKey{shoe, up, h17}
+	dealerCache.Lock()
+	out, ok := dealerCache.odds[key]
+	dealerCache.Unlock()
+	if ok {
+		return out
+	}
+
+	var draw func(hard int, aces bool, n int, p float64)
+	draw = func(hard int, aces bool, n int, p float64) {
+		total := handTotal(hard, aces)
+		switch {
+		case hard > 21:
+			out[0] += p
+		case total > 17 || total == 17 && !(aces && hard+10 == 17 && h17) || n == 0:
+			out[total] += p
+		default:
+			for v := 1; v <= 10; v++ {
+				if shoe[v] == 0 {
+					continue
+				}
+				q := p * float64(shoe[v]) / float64(n)
+				shoe[v]--
+				draw(hard+v, aces || v == 1, n-1, q)
+				shoe[v]++
+			}
+		}
+	}
+
+	// players only act when the dealer's hole card doesn't make blackjack
+	holes := 0
+	for v := 1; v <= 10; v++ {
+		if !makesBlackjack(up, v) {
+			holes += shoe[v]
+		}
+	}
+	for v := 1; v <= 10; v++ {
+		if shoe[v] == 0 || makesBlackjack(up, v) {
+			continue
+		}
+		p := float64(shoe[v]) / float64(holes)
+		shoe[v]--
+		draw(up+v, up == 1 || v == 1, shoe.total(), p)
+		shoe[v]++
+	}
+
+	dealerCache.Lock()
+	if len(dealerCache.odds) >= evCacheSize {
+		clear(dealerCache.odds)
+	}
+	dealerCache.odds[key] = out
+	dealerCache.Unlock()
+	return out
+}
+
+type playerKey struct {
+	shoe shoeCounts
+	hard int
+	aces bool
+}
+
+// expected value of each play for one hand per unit bet, drawing without replacement from the shoe
+type evCalc struct {
+	up    int
+	rules tableRules
+	memo  map[playerKey]float64
+}
+
+func (e *evCalc) stand(shoe shoeCounts, total int) float64 {
+	if total > 21 {
+		return -1
+	}
+
+	ev := 0.0
+	for d, p := range dealerOutcomes(shoe, e.up, e.rules.DealerHitsSoft17) {
+		if total > d {
+			ev += p
+		} else if total < d {
+			ev -= p
+		}
+	}
+	return ev
+}
+
+// best of standing and hitting, the table moves on by itself at 21
+func (e *evCalc) play(shoe shoeCounts, hard int, aces bool) float64 {
+	total := handTotal(hard, aces)
+	if total >= 21 {
+		return e.stand(shoe, total)
+	}
+
+	key := playerKey{shoe, hard, aces}
+	if ev, ok := e.memo[key]; ok {
+		return ev
+	}
+
+	ev := math.Max(e.stand(shoe, total), e.hit(shoe, hard, aces))
+	e.memo[key] = ev
+	return ev
+}
+
+func (e *evCalc) hit(shoe shoeCounts, hard int, aces bool) float64 {
+	n := shoe.total()
+	ev := 0.0
+	for v := 1; v <= 10; v++ {
+		if shoe[v] == 0 {
+			continue
+		}
+		p := float64(shoe[v]) / float64(n)
+		shoe[v]--
+		ev += p * e.play(shoe, hard+v, aces || v == 1)
+		shoe[v]++
+	}
+	return ev
+}
+
+func (e *evCalc) double(shoe shoeCounts, hard int, aces bool) float64 {
+	n := shoe.total()
+	ev := 0.0
+	for v := 1; v <= 10; v++ {
+		if shoe[v] == 0 {
+			continue
+		}
+		p := float64(shoe[v]) / float64(n)
+		shoe[v]--
+		ev += p * 2 * e.stand(shoe, handTotal(hard+v, aces || v == 1))
+		shoe[v]++
+	}
+	return ev
+}
+
+// both halves of a split pair, each drawn to from the same shoe and played without splitting again; shoe is without
+// either card of the pair. Tables let pairs be split again, so unlike the other plays this is an approximation
+func (e *evCalc) split(shoe shoeCounts, v int) float64 {
+	n := shoe.total()
+	ev := 0.0
+	for w := 1; w <= 10; w++ {
+		if shoe[w] == 0 {
+			continue
+		}
+		p := float64(shoe[w]) / float64(n)
+		shoe[w]--
+		hard, aces := v+w, v == 1 || w == 1
+		best := e.play(shoe, hard, aces)
+		if e.rules.DoubleAfterSplit && handTotal(hard, aces) < 21 {
+			best = math.Max(best, e.double(shoe, hard, aces))
+		}
+		ev += p * best
+		shoe[w]++
+	}
+	return 2 * ev
+}
+
+// expected value per unit bet of every play the hand can make right now, with shoe holding every card the player hasn't seen;
+// exact except for split, see evCalc.split
+func moveValues(h Hand, up card, shoe shoeCounts, rules tableRules, canDouble bool, canSplit bool, canSurrender bool) map[move]float64 {
+	e := &evCalc{up: up.value(), rules: rules, memo: make(map[playerKey]float64)}
+
+	hard, aces := 0, false
+	for _, c := range h.Cards {
+		hard += c.value()
+		aces = aces || c.Rank == Ace
+	}
+
+	firstTwo := len(h.Cards) == 2
+	values := map[move]float64{
+		Stand: e.stand(shoe, handTotal(hard, aces)),
+		Hit:   e.hit(shoe, hard, aces),
+	}
+	if canDouble && firstTwo {
+		values[Double] = e.double(shoe, hard, aces)
+	}
+	if canSplit && firstTwo && h.Cards[0].value() == h.Cards[1].value() {
+		values[Split] = e.split(shoe, h.Cards[0].value())
+	}
+	if canSurrender && firstTwo {
+		values[Surrender] = -0.5
+	}
+	return values
+}
+
+// a hand to work out, the shoe is either given by its counts or as full decks less the cards already seen
+type EVRequest struct {
+	Hand   []card     `json:"hand"`
+	Dealer card       `json:"dealer"` // up card
+	Split  bool       `json:"split"`  // the hand came from a split
+	Decks  int        `json:"decks"`
+	Seen   []card     `json:"seen"`  // cards dealt from the shoe besides the hand and up card
+	Shoe   []int      `json:"shoe"`  // cards left by value, aces first and tens last, instead of decks and seen
+	Rules  tableRules `json:"rules"` // defaults to the default table's rules
+}
+
+type EVResponse struct {
+	Values      map[move]float64 `json:"values"` // per unit bet
+	Best        move             `json:"best"`
+	Approximate []move           `json:"approximate"` // plays whose value is an estimate rather than exact
+}
+
+// expected value of every play open to a hand, exact except for split which leaves out splitting again;
+// money is left out, so doubling and splitting are always affordable
+func ExpectedValues(req EVRequest) (EVResponse, error) {
+	if req.Rules.BlackjackPays == "" {
+		req.Rules = defaultTableConfig().Rules
+	}
+
+	switch {
+	case len(req.Hand) < 1:
+		return EVResponse{}, errors.New("hand needs at least one card")
+	case req.Dealer.Rank == 0:
+		return EVResponse{}, errors.New("dealer needs an up card")
+	case req.Shoe == nil && (req.Decks < 1 || req.Decks > 8):
+		return EVResponse{}, errors.New("decks must be between 1 and 8")
+	case req.Shoe != nil && len(req.Shoe) != 10:
+		return EVResponse{}, errors.New("shoe must have 10 counts")
+	}
+
+	for _, c := range append(append([]card{req.Dealer}, req.Hand...), req.Seen...) {
+		if c.Rank < Ace || c.Rank > King {
+			return EVResponse{}, errors.New("card ranks must be between 1 and 13")
+		}
+	}
+
+	var shoe shoeCounts
+	if req.Shoe != nil {
+		copy(shoe[1:], req.Shoe)
+	} else {
+		shoe = fullShoe(req.Decks)
+		shoe.remove(req.Hand...)
+		shoe.remove(req.Dealer)
+		shoe.remove(req.Seen...)
+	}
+
+	h := Hand{Cards: req.Hand}
+	for _, c := range shoe[1:] {
+		if c < 0 {
+			return EVResponse{}, errors.New("more cards seen than the shoe holds")
+		}
+	}
+	switch {
+	case shoe.total() < 20:
+		return EVResponse{}, errors.New("shoe needs at least 20 cards")
+	case h.bestScore() == 0:
+		return EVResponse{}, errors.New("hand has bust")
+	}
+
+	canDouble := !req.Split || req.Rules.DoubleAfterSplit
+	canSurrender := req.Rules.Surrender && !req.Split
+	values := moveValues(h, req.Dealer, shoe, req.Rules, canDouble, true, canSurrender)
+
+	res := EVResponse{Values: values, Best: Stand, Approximate: []move{}}
+	if _, ok := values[Split]; ok {
+		res.Approximate = append(res.Approximate, Split)
+	}
+	for m, v := range values {
+		if v > values[res.Best] {
+			res.Best = m
+		}
+	}
+	return res, nil
+}
+
+func (server *server) handleEVRequest(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		// every request is worked out from scratch, so addresses get a budget like token checks do
+		ip := ipKey(clientIP(r))
+		if left, ok := server.limits.banned(ip); ok {
+			writeRateLimited(w, left, "temporarily banned")
+			return
+		}
+		c := server.config.Limits
+		if !server.limits.allow("ev", ip, c.EVRate, c.EVBurst) {
+			server.limits.strike(ip)
+			writeRateLimited(w, time.Duration(float64(time.Second)/c.EVRate), "too many requests")
+			return
+		}
+
+		var req EVRequest
+		decoder := json.NewDecoder(r.Body)
+		decoder.DisallowUnknownFields()
+		err := decoder.Decode(&req)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, errorResponse{err.Error()})
+			return
+		}
+
+		res, err := ExpectedValues(req)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, errorResponse{err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, res)
+	}
+}
@@ -0,0 +1,246 @@
+package game
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+type grantKind string
+
+const (
+	GrantDailyBonus grantKind = "dailyBonus"
+	GrantRebuy      grantKind = "rebuy"
+	GrantAdmin      grantKind = "admin"
+)
+
+// money given to a player outside of play
+type grant struct {
+	Time    time.Time `json:"time"`
+	UID     string    `json:"playerId"`
+	Kind    grantKind `json:"kind"`
+	Amount  int64     `json:"amount"`
+	Reason  string    `json:"reason"`
+	Balance int64     `json:"balance"` // balance before the grant
+}
+
+// record of every grant, used to enforce cooldowns; callers hold server.grantLock
+type grantStore interface {
+	add(g grant) error
+	// returns the player's most recent grant of a kind
+	last(uid string, kind grantKind) (grant, bool)
+}
+
+type memoryGrants struct {
+	latest map[string]grant // keyed by kind and uid
+}
+
+func newMemoryGrants() *memoryGrants {
+	return &memoryGrants{latest: make(map[string]grant)}
+}
+
+func (g *memoryGrants) add(gr grant) error {
+	g.latest[string(gr.Kind)+"/"+gr.UID] = gr
+	return nil
+}
+
+func (g *memoryGrants) last(uid string, kind grantKind) (grant, bool) {
+	gr, ok := g.latest[string(kind)+"/"+uid]
+	return gr, ok
+}
+
+// appends every grant to a JSON lines file
+type fileGrants struct {
+	*memoryGrants
+	file *os.File
+}
+
+func newFileGrants(path string) (*fileGrants, error) {
+	f, grants, err := openJSONLines[grant](path)
+	if err != nil {
+		return nil, err
+	}
+
+	g := &fileGrants{newMemoryGrants(), f}
+	for _, gr := range grants {
+		g.memoryGrants.add(gr)
+	}
+	return g, nil
+}
+
+func (g *fileGrants) add(gr grant) error {
+	err := appendJSONLines(g.file, gr)
+	if err != nil {
+		return err
+	}
+	return g.memoryGrants.add(gr)
+}
+
+// wrapped by every reason a player can't get a grant right now
+var errNotEligible = errors.New("not eligible")
+
+// checks a grant against the replenishment policy, records it and pays it out
+// eligible decides the amount from the player's balance, or returns an error if they get nothing
+func (server *server) grant(uid string, kind grantKind, reason string, eligible func(balance int64, last grant, ok bool) (int64, error)) (grant, error) {
+	// the wallet has to be loaded to know the balance
+	money, err := server.store.load(server.ctx, uid, server.config.StartingMoney)
+	if err != nil {
+		return grant{}, err
+	}
+	server.setMoney(uid, money)
+
+	// decide and record under one lock so two requests can't both pass a cooldown check
+	server.grantLock.Lock()
+	defer server.grantLock.Unlock()
+
+	balance := server.getMoney(uid)
+	last, ok := server.grants.last(uid, kind)
+	amount, err := eligible(balance, last, ok)
+	if err != nil {
+		return grant{}, err
+	}
+
+	g := grant{Time: time.Now(), UID: uid, Kind: kind, Amount: amount, Reason: reason, Balance: balance}
+	err = server.grants.add(g)
+	if err != nil {
+		return grant{}, err
+	}
+
+	server.payGrant(g)
+	slog.Info("granted money", "uid", uid, "kind", kind, "amount", g.Amount, "reason", reason)
+	return g, nil
+}
+
+// pays a grant through a table the player is connected to, so the table sees it in order with its own events
+func (server *server) payGrant(g grant) {
+	// a stuck table would otherwise hold roomLock, and with it every room being added or closed
+	for _, room := range server.allRooms() {
+		if room.training || !room.hasClient(g.UID) {
+			continue
+		}
+		select {
+		case room.grants <- g:
+			return
+		case <-room.drained:
+		}
+	}
+	server.deltaMoney(g.UID, g.Amount, grantReason(g.Kind, g.UID, g.Reason))
+}
+
+// gives the daily bonus on the player's first connection of the day (UTC)
+func (server *server) dailyBonus(uid string) {
+	bonus := server.config.Replenish.DailyBonus
+	if bonus == 0 {
+		return
+	}
+
+	_, err := server.grant(uid, GrantDailyBonus, "daily login bonus", func(balance int64, last grant, ok bool) (int64, error) {
+		today := time.Now().UTC().Truncate(24 * time.Hour)
+		if ok && !last.Time.Before(today) {
+			return 0, errNotEligible
+		}
+		return bonus, nil
+	})
+	if err != nil && !errors.Is(err, errNotEligible) {
+		slog.Error("error granting daily bonus", "uid", uid, "err", err)
+	}
+}
+
+// tops up a player below the rebuy floor back to it, at most once per cooldown
+func (server *server) rebuy(uid string) (grant, error) {
+	floor := server.config.Replenish.RebuyFloor
+	cooldown := time.Duration(server.config.Replenish.RebuyCooldown) * time.Millisecond
+
+	return server.grant(uid, GrantRebuy, "bust-out rebuy", func(balance int64, last grant, ok bool) (int64, error) {
+		if floor == 0 {
+			return 0, fmt.Errorf("%w: rebuys are disabled", errNotEligible)
+		}
+		if balance >= floor {
+			return 0, fmt.Errorf("%w: balance must be below %d to rebuy", errNotEligible, floor)
+		}
+		if next := last.Time.Add(cooldown); ok && time.Now().Before(next) {
+			return 0, fmt.Errorf("%w: next rebuy available at %s", errNotEligible, next.UTC().Format(time.RFC3339))
+		}
+		return floor - balance, nil
+	})
+}
+
+func (server *server) handleRebuyRequest(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		if !server.allowAuth(w, r) {
+			return
+		}
+		token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		uid, _, err := server.auth.verify(r.Context(), token)
+		if err != nil {
+			authFailuresCounter.WithLabelValues("rebuy").Inc()
+			writeJSON(w, http.StatusUnauthorized, errorResponse{"invalid token"})
+			return
+		}
+
+		g, err := server.rebuy(uid)
+		if errors.Is(err, errNotEligible) {
+			writeJSON(w, http.StatusForbidden, errorResponse{err.Error()})
+			return
+		}
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, errorResponse{err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, g)
+	}
+}
+
+type adminGrantRequest struct {
+	UID    string `json:"playerId"`
+	Amount int64  `json:"amount"`
+	Reason string `json:"reason"`
+}
+
+// whether the request carries the configured admin token
+func (server *server) isAdmin(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && server.config.AdminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(server.config.AdminToken)) == 1
+}
+
+func (server *server) handleAdminGrantRequest(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		var req adminGrantRequest
+		decoder := json.NewDecoder(r.Body)
+		decoder.DisallowUnknownFields()
+		err := decoder.Decode(&req)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, errorResponse{err.Error()})
+			return
+		}
+		if req.UID == "" || req.Amount == 0 || strings.TrimSpace(req.Reason) == "" {
+			writeJSON(w, http.StatusBadRequest, errorResponse{"playerId, a non-zero amount and a reason are required"})
+			return
+		}
+
+		// negative amounts take money away, but never more than the player has
+		g, err := server.grant(req.UID, GrantAdmin, req.Reason, func(balance int64, _ grant, _ bool) (int64, error) {
+			if balance+req.Amount < 0 {
+				return 0, fmt.Errorf("%w: balance is only %d", errNotEligible, balance)
+			}
+			return req.Amount, nil
+		})
+		if errors.Is(err, errNotEligible) {
+			writeJSON(w, http.StatusConflict, errorResponse{err.Error()})
+			return
+		}
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, errorResponse{err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, g)
+	}
+}
@@ -6,9 +6,12 @@ import (
 )
 
 type playerCommand struct {
-	Action string
-	Bet    int64
-	Seat   int
+	Action     string
+	Bet        int64
+	Seat       int
+	Enabled    bool  // autoBet: whether auto-bet is on
+	StopLosses int   // autoBet: losses in a row before auto-bet switches off
+	StopBelow  int64 // autoBet: money floor before auto-bet switches off
 }
 
 // data sent to all players whenever game state changes
@@ -19,14 +22,32 @@ type broadcast struct {
 	ActiveHand  int         `json:"activeHand"`
 	TableStatus tableStatus `json:"status"`
 	Time        int64       `json:"time"`
+	Deadline    int64       `json:"deadline"`
+	Closing     bool        `json:"closing"` // server is shutting down once this round ends
+}
+
+// data sent to a single player only
+type privateMessage struct {
+	Hint   *hintMessage    `json:"hint,omitempty"`
+	Grade  *gradeMessage   `json:"grade,omitempty"`  // training tables: how a play compared to basic strategy
+	Report *trainingReport `json:"report,omitempty"` // training tables: accuracy so far this session
+}
+
+type hintMessage struct {
+	Hand   int  `json:"hand"`
+	Action move `json:"action"`
 }
 
 func (table *table) handlePlayerUpdate(cmd playersUpdate) {
 	switch cmd.connect {
 	case true:
 		if table.playerWithUID(cmd.playerId) == nil {
-			table.Players = append(table.Players, player{UID: cmd.playerId, DisplayName: cmd.displayName, active: true})
+			table.Players = append(table.Players, player{UID: cmd.playerId, DisplayName: cmd.displayName, Bot: isBot(cmd.playerId), active: true, timeBank: table.timeBank})
 		} else {
+			// the session ended when they disconnected, so they get its report now that they can be reached
+			if !table.playerWithUID(cmd.playerId).active {
+				table.endSession(cmd.playerId)
+			}
 			table.playerWithUID(cmd.playerId).active = true
 		}
 		table.broadcast()
@@ -52,19 +73,29 @@ func (table *table) handlePlayerUpdate(cmd playersUpdate) {
 func (table *table) handleWSCommand(cmd wsCommand) {
 	var pc playerCommand
 	err := json.Unmarshal(cmd.message, &pc)
-	if err != nil {
+	if err != nil || eventType(pc.Action).internal() {
 		return
 	}
 
-	table.handleCommand(cmd.playerId, pc)
+	table.apply(roundEvent{Type: EventCommand, Time: time.Now(), UID: cmd.playerId, Command: &pc})
 }
 
 func (table *table) handleCommand(uid string, cmd playerCommand) {
+	table.metrics.action(cmd.Action)
+
 	switch cmd.Action {
 	case "join":
 		table.join(uid, cmd.Seat)
 	case "leave":
 		table.leave(uid, cmd.Seat)
+	case "sitOut":
+		table.sitOut(uid, cmd.Seat)
+	case "sitIn":
+		table.sitIn(uid, cmd.Seat)
+	case "autoBet":
+		table.setAutoBet(uid, cmd.Seat, cmd.Enabled, cmd.StopLosses, cmd.StopBelow)
+	case "report":
+		table.sendReport(uid)
 	}
 
 	switch table.status {
@@ -76,28 +107,49 @@ func (table *table) handleCommand(uid string, cmd playerCommand) {
 }
 
 func (table *table) handleNullAction() {
+	table.metrics.timeout(table.status)
+
 	switch table.status {
 	case Betting:
-		for i := range table.Hands {
-			if table.Hands[i].PlayerUID != "" && table.Hands[i].Bet == 0 {
-				table.Hands[i].PlayerUID = ""
-			}
-		}
-		table.broadcast()
-		table.startPlayerTurn()
+		table.dealWithoutWaiting()
 
 	case PlayerTurn:
+		switch table.timeoutAction {
+		case TimeoutBasicStrategy:
+			table.playBasicStrategy()
+		case TimeoutSurrender:
+			table.surrender()
+		}
 		table.advanceHand()
 	}
 }
 
+// deals the bets that are in; seats that didn't bet sit out instead of being released
+func (table *table) dealWithoutWaiting() {
+	for i := range table.Hands {
+		if table.Hands[i].PlayerUID != "" && table.Hands[i].Bet == 0 {
+			table.Hands[i].SittingOut = true
+		}
+	}
+	table.broadcast()
+	table.startPlayerTurn()
+}
+
 func (table *table) handleBettingCommand(uid string, cmd playerCommand) {
 	switch cmd.Action {
 	case "bet":
 		table.enterBet(uid, cmd.Bet, cmd.Seat)
+	case "rebet":
+		table.rebet(uid, cmd.Seat)
+	case "rebetAndDeal":
+		// deal as if betting time ran out; this player's other seats without a bet sit out
+		if table.rebetAndDeal(uid, cmd.Seat) {
+			table.dealWithoutWaiting()
+			return
+		}
 	}
 
-	if table.allBetsIn() {
+	if table.status == Betting && table.allBetsIn() {
 		table.startPlayerTurn()
 	}
 }
@@ -108,6 +160,18 @@ func (table *table) handleActionCommand(uid string, cmd playerCommand) {
 		return
 	}
 
+	// grade against the hand as it is before the play
+	var grade gradeMessage
+	switch m := move(cmd.Action); m {
+	case Hit, Stand, Double, Split, Surrender:
+		if !player.Bot {
+			table.metrics.decision(table.now.Sub(table.actionTimeStart).Seconds())
+		}
+		if table.training {
+			grade = table.gradeMove(m)
+		}
+	}
+
 	end, success := false, false
 	switch cmd.Action {
 	case "hit":
@@ -119,12 +183,25 @@ func (table *table) handleActionCommand(uid string, cmd playerCommand) {
 		success = end
 	case "split":
 		success = table.split()
+	case "surrender":
+		end = table.surrender()
+		success = end
+	case "timeBank":
+		table.useTimeBank(uid)
+		return
+	case "hint":
+		table.hint(uid)
+		return
+	}
+
+	if success && grade.Action != "" {
+		table.sendGrade(uid, grade)
 	}
 
 	if end || table.bust() || table.Hands[table.ActiveHand].bestScore() == 21 {
 		table.advanceHand()
 	} else if success {
-		table.actionTimeStart = time.Now()
+		table.restartActionTimer()
 		table.broadcast()
 	}
 }
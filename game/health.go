@@ -0,0 +1,146 @@
+package game
+
+import (
+	"cmp"
+	"context"
+	"net/http"
+	"slices"
+	"sync/atomic"
+	"time"
+)
+
+// answers as long as the process is serving requests
+func (server *server) handleHealthRequest(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	}
+}
+
+type readyResponse struct {
+	Ready  bool              `json:"ready"`
+	Checks map[string]string `json:"checks"` // "ok" or why the check failed
+}
+
+// ready when the auth provider and wallet store can be reached and the server isn't shutting down
+func (server *server) handleReadyRequest(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+
+		res := readyResponse{Ready: true, Checks: make(map[string]string)}
+		check := func(name string, err error) {
+			res.Checks[name] = "ok"
+			if err != nil {
+				res.Ready = false
+				res.Checks[name] = err.Error()
+			}
+		}
+		check("auth", server.auth.ping(ctx))
+		check("store", server.store.ping(ctx))
+
+		res.Checks["draining"] = "no"
+		if server.draining.Load() {
+			res.Ready = false
+			res.Checks["draining"] = "yes"
+		}
+
+		status := http.StatusOK
+		if !res.Ready {
+			status = http.StatusServiceUnavailable
+		}
+		writeJSON(w, status, res)
+	}
+}
+
+// kept up to date by a room's goroutines for its debug dump, times are unix nanoseconds and 0 when unset
+type roomDebug struct {
+	running           atomic.Bool  // startTable hasn't returned
+	timeoutAt         atomic.Int64 // when the action timer fires
+	botAt             atomic.Int64 // when the next bot acts
+	snapshotAt        atomic.Int64 // when the next snapshot is taken
+	sendingSince      atomic.Int64 // when the broadcaster started writing its current message
+	commandsWaiting   atomic.Int64 // connections blocked handing the table a command
+	broadcastsWaiting atomic.Int64 // messages blocked waiting for the broadcaster
+}
+
+// milliseconds from now until t, nil if t isn't set
+func untilTime(t *atomic.Int64, now time.Time) *int64 {
+	v := t.Load()
+	if v == 0 {
+		return nil
+	}
+	ms := time.Unix(0, v).Sub(now).Milliseconds()
+	return &ms
+}
+
+type roomDump struct {
+	Code              string `json:"code"`
+	TableRunning      bool   `json:"tableRunning"`
+	Responsive        bool   `json:"responsive"` // the table answered within a second
+	Status            string `json:"status,omitempty"`
+	Clients           int    `json:"clients"`
+	CommandsWaiting   int64  `json:"commandsWaiting"`
+	BroadcastsWaiting int64  `json:"broadcastsWaiting"`
+	SendingFor        *int64 `json:"sendingFor"` // ms the broadcaster has spent on its current message, null if idle
+	NextTimeout       *int64 `json:"nextTimeout"`
+	NextBot           *int64 `json:"nextBot"`
+	NextSnapshot      *int64 `json:"nextSnapshot"`
+}
+
+func (room *room) dump() roomDump {
+	now := time.Now()
+	d := roomDump{
+		Code:              room.code,
+		TableRunning:      room.debug.running.Load(),
+		CommandsWaiting:   room.debug.commandsWaiting.Load(),
+		BroadcastsWaiting: room.debug.broadcastsWaiting.Load(),
+		NextTimeout:       untilTime(&room.debug.timeoutAt, now),
+		NextBot:           untilTime(&room.debug.botAt, now),
+		NextSnapshot:      untilTime(&room.debug.snapshotAt, now),
+	}
+	if since := room.debug.sendingSince.Load(); since != 0 {
+		ms := now.Sub(time.Unix(0, since)).Milliseconds()
+		d.SendingFor = &ms
+	}
+
+	room.clientLock.Lock()
+	d.Clients = len(room.clients)
+	room.clientLock.Unlock()
+
+	// a stuck table never takes the request, so don't wait on it for long
+	reply := make(chan tableSnapshot, 1)
+	select {
+	case room.inspect <- reply:
+		d.Responsive = true
+		d.Status = (<-reply).Status.String()
+	case <-room.drained:
+	case <-time.After(time.Second):
+	}
+	return d
+}
+
+func (server *server) handleDebugRoomsRequest(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		if code := r.PathValue("room"); code != "" {
+			room, ok := server.room(code)
+			if !ok {
+				writeJSON(w, http.StatusNotFound, errorResponse{"room not found"})
+				return
+			}
+			writeJSON(w, http.StatusOK, room.dump())
+			return
+		}
+
+		dumps := []roomDump{}
+		for _, room := range server.allRooms() {
+			dumps = append(dumps, room.dump())
+		}
+		slices.SortFunc(dumps, func(a, b roomDump) int {
+			return cmp.Compare(a.Code, b.Code)
+		})
+		writeJSON(w, http.StatusOK, dumps)
+	}
+}
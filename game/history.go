@@ -0,0 +1,419 @@
+package game
+
+import (
+	"encoding/csv"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type handResult string
+
+const (
+	ResultWin       handResult = "win"
+	ResultBlackjack handResult = "blackjack"
+	ResultPush      handResult = "push"
+	ResultLoss      handResult = "loss"
+	ResultBust      handResult = "bust"
+	ResultSurrender handResult = "surrender"
+	ResultRefunded  handResult = "refunded"
+)
+
+type handAction struct {
+	Action string    `json:"action"`
+	Time   time.Time `json:"time"`
+}
+
+// one settled hand of a completed round
+type handRecord struct {
+	RoundID string       `json:"roundId"`
+	Room    string       `json:"room"`
+	Started time.Time    `json:"started"`
+	Ended   time.Time    `json:"ended"`
+	UID     string       `json:"playerId"`
+	Seat    int          `json:"seat"`
+	Hand    int          `json:"hand"`
+	Cards   []card       `json:"cards"`
+	Dealer  []card       `json:"dealer"`
+	Actions []handAction `json:"actions"`
+	Bet     int64        `json:"bet"` // total staked, including doubles
+	Payout  int64        `json:"payout"`
+	Net     int64        `json:"net"`
+	Result  handResult   `json:"result"`
+	Split   bool         `json:"split"` // played from either half of a split
+	Doubled bool         `json:"doubled"`
+}
+
+// turns a finished round into one record per hand that was played
+func handRecords(room string, l roundLog) []handRecord {
+	byHand := make(map[int]*handRecord)
+	seat := -1
+	for i, h := range l.Hands {
+		if !h.Split {
+			seat++
+		}
+		if h.PlayerUID == "" || len(h.Cards) == 0 {
+			continue
+		}
+
+		byHand[i] = &handRecord{
+			RoundID: l.ID,
+			Room:    room,
+			Started: l.Started,
+			Ended:   l.Ended,
+			UID:     h.PlayerUID,
+			Seat:    seat,
+			Hand:    i,
+			Cards:   h.Cards,
+			Dealer:  l.Dealer,
+			Actions: []handAction{},
+			Split:   h.fromSplit,
+		}
+	}
+
+	refunded := make(map[int]bool)
+	for _, e := range l.Events {
+		r, ok := byHand[e.Hand]
+		if !ok {
+			continue
+		}
+
+		switch {
+		case e.Type == EventSettle:
+			r.Bet, r.Payout, r.Result = e.Bet, e.Payout, e.Result
+		case e.Type == EventRefund:
+			r.Bet, r.Payout = e.Bet, e.Bet
+			refunded[e.Hand] = true
+		case e.Type == EventTimeout:
+			r.Actions = append(r.Actions, handAction{string(EventTimeout), e.Time})
+		case e.Command != nil && e.UID == r.UID:
+			switch move(e.Command.Action) {
+			case Hit, Stand, Double, Split, Surrender:
+				r.Actions = append(r.Actions, handAction{e.Command.Action, e.Time})
+			}
+		}
+	}
+
+	records := []handRecord{}
+	for i := range l.Hands {
+		r, ok := byHand[i]
+		if !ok {
+			continue
+		}
+
+		r.Net = r.Payout - r.Bet
+		r.Doubled = l.Hands[i].doubled
+		if refunded[i] {
+			r.Result = ResultRefunded
+		}
+		records = append(records, *r)
+	}
+	return records
+}
+
+// a stretch of time a player had at least one connection open
+type sessionRecord struct {
+	UID     string    `json:"playerId"`
+	Started time.Time `json:"started"`
+	Ended   time.Time `json:"ended"`
+}
+
+type historyFilter struct {
+	UID    string
+	Room   string
+	Result handResult
+	From   time.Time
+	To     time.Time
+}
+
+func (f historyFilter) matches(r handRecord) bool {
+	return (f.UID == "" || r.UID == f.UID) &&
+		(f.Room == "" || r.Room == f.Room) &&
+		(f.Result == "" || r.Result == f.Result) &&
+		(f.From.IsZero() || !r.Ended.Before(f.From)) &&
+		(f.To.IsZero() || r.Ended.Before(f.To))
+}
+
+// storage for completed hands and sessions
+type historyStore interface {
+	add(records []handRecord) error
+	// returns matching hands, newest first
+	query(f historyFilter) ([]handRecord, error)
+	addSession(s sessionRecord) error
+	// returns every session, oldest first
+	sessions() ([]sessionRecord, error)
+}
+
+// keeps hand history in memory only
+type memoryHistory struct {
+	records        []handRecord
+	sessionRecords []sessionRecord
+	lock           sync.RWMutex
+}
+
+func (h *memoryHistory) add(records []handRecord) error {
+	h.lock.Lock()
+	h.records = append(h.records, records...)
+	h.lock.Unlock()
+	return nil
+}
+
+func (h *memoryHistory) query(f historyFilter) ([]handRecord, error) {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	matches := []handRecord{}
+	for i := len(h.records) - 1; i >= 0; i-- {
+		if f.matches(h.records[i]) {
+			matches = append(matches, h.records[i])
+		}
+	}
+	return matches, nil
+}
+
+func (h *memoryHistory) addSession(s sessionRecord) error {
+	h.lock.Lock()
+	h.sessionRecords = append(h.sessionRecords, s)
+	h.lock.Unlock()
+	return nil
+}
+
+func (h *memoryHistory) sessions() ([]sessionRecord, error) {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	return slices.Clone(h.sessionRecords), nil
+}
+
+// one line of the history file, a hand or, with Session set, a session
+type historyLine struct {
+	handRecord
+	Session *sessionRecord `json:"session,omitempty"`
+}
+
+// appends hand history to a JSON lines file, everything in it is loaded into memory on startup
+type fileHistory struct {
+	memoryHistory
+	file *os.File
+}
+
+func newFileHistory(path string) (*fileHistory, error) {
+	f, lines, err := openJSONLines[historyLine](path)
+	if err != nil {
+		return nil, err
+	}
+
+	h := &fileHistory{file: f}
+	for _, l := range lines {
+		if l.Session != nil {
+			h.sessionRecords = append(h.sessionRecords, *l.Session)
+		} else {
+			h.records = append(h.records, l.handRecord)
+		}
+	}
+	return h, nil
+}
+
+func (h *fileHistory) add(records []handRecord) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	err := appendJSONLines(h.file, records...)
+	if err != nil {
+		return err
+	}
+	h.records = append(h.records, records...)
+	return nil
+}
+
+func (h *fileHistory) addSession(s sessionRecord) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	err := appendJSONLines(h.file, struct {
+		Session sessionRecord `json:"session"`
+	}{s})
+	if err != nil {
+		return err
+	}
+	h.sessionRecords = append(h.sessionRecords, s)
+	return nil
+}
+
+// reads filters and pagination shared by the history endpoints
+func parseHistoryQuery(r *http.Request) (historyFilter, int, int, error) {
+	q := r.URL.Query()
+	f := historyFilter{UID: q.Get("player"), Room: q.Get("room"), Result: handResult(q.Get("result"))}
+
+	var err error
+	if v := q.Get("from"); v != "" {
+		f.From, err = parseHistoryTime(v)
+		if err != nil {
+			return f, 0, 0, errors.New("from must be RFC 3339 or YYYY-MM-DD")
+		}
+	}
+	if v := q.Get("to"); v != "" {
+		f.To, err = parseHistoryTime(v)
+		if err != nil {
+			return f, 0, 0, errors.New("to must be RFC 3339 or YYYY-MM-DD")
+		}
+	}
+
+	page, pageSize, err := parsePage(q)
+	return f, page, pageSize, err
+}
+
+// reads page (1-based) and pageSize (default 50, at most 500)
+func parsePage(q url.Values) (int, int, error) {
+	var err error
+	page, pageSize := 1, 50
+	if v := q.Get("page"); v != "" {
+		page, err = strconv.Atoi(v)
+		if err != nil || page < 1 {
+			return 0, 0, errors.New("page must be a positive integer")
+		}
+	}
+	if v := q.Get("pageSize"); v != "" {
+		pageSize, err = strconv.Atoi(v)
+		if err != nil || pageSize < 1 || pageSize > 500 {
+			return 0, 0, errors.New("pageSize must be between 1 and 500")
+		}
+	}
+	return page, pageSize, nil
+}
+
+func parseHistoryTime(v string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		t, err = time.Parse(time.DateOnly, v)
+	}
+	return t, err
+}
+
+type historyResponse struct {
+	Hands    []handRecord `json:"hands"`
+	Page     int          `json:"page"`
+	PageSize int          `json:"pageSize"`
+	Total    int          `json:"total"`
+}
+
+// lets the admin see anyone's records and players only their own, or any room's when uid is empty;
+// writes the error and returns false for anyone else
+func (server *server) allowRecords(w http.ResponseWriter, r *http.Request, uid string, endpoint string) bool {
+	if server.isAdmin(r) {
+		return true
+	}
+	if !server.allowAuth(w, r) {
+		return false
+	}
+
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	caller, _, err := server.auth.verify(r.Context(), token)
+	if err != nil {
+		authFailuresCounter.WithLabelValues(endpoint).Inc()
+		writeJSON(w, http.StatusUnauthorized, errorResponse{"invalid token"})
+		return false
+	}
+	if uid != "" && caller != uid {
+		writeJSON(w, http.StatusForbidden, errorResponse{"players can only see their own records"})
+		return false
+	}
+	return true
+}
+
+func (server *server) handlePlayerHandsRequest(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		if !server.allowRecords(w, r, r.PathValue("uid"), "hands") {
+			return
+		}
+		f, page, pageSize, err := parseHistoryQuery(r)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, errorResponse{err.Error()})
+			return
+		}
+
+		f.UID = r.PathValue("uid")
+		server.writeHistory(w, r, f, page, pageSize)
+	}
+}
+
+func (server *server) handleRoomHistoryRequest(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		if !server.allowRecords(w, r, "", "history") {
+			return
+		}
+		f, page, pageSize, err := parseHistoryQuery(r)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, errorResponse{err.Error()})
+			return
+		}
+
+		f.Room = r.PathValue("room")
+		server.writeHistory(w, r, f, page, pageSize)
+	}
+}
+
+// responds with one page of matching hands, or every matching hand when exporting with format=json or format=csv
+func (server *server) writeHistory(w http.ResponseWriter, r *http.Request, f historyFilter, page int, pageSize int) {
+	records, err := server.history.query(f)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, errorResponse{err.Error()})
+		return
+	}
+
+	switch r.URL.Query().Get("format") {
+	case "":
+		start := min((page-1)*pageSize, len(records))
+		end := min(start+pageSize, len(records))
+		writeJSON(w, http.StatusOK, historyResponse{records[start:end], page, pageSize, len(records)})
+	case "json":
+		w.Header().Set("Content-Disposition", `attachment; filename="hands.json"`)
+		writeJSON(w, http.StatusOK, records)
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="hands.csv"`)
+		w.WriteHeader(http.StatusOK)
+		writeHandsCSV(w, records)
+	default:
+		writeJSON(w, http.StatusBadRequest, errorResponse{"format must be json or csv"})
+	}
+}
+
+func writeHandsCSV(w http.ResponseWriter, records []handRecord) {
+	out := csv.NewWriter(w)
+	out.Write([]string{"roundId", "room", "started", "ended", "playerId", "seat", "hand", "cards", "dealer", "actions", "bet", "payout", "net", "result", "split", "doubled"})
+
+	for _, r := range records {
+		actions := make([]string, len(r.Actions))
+		for i, a := range r.Actions {
+			actions[i] = a.Action + "@" + a.Time.Format(time.RFC3339Nano)
+		}
+
+		out.Write([]string{
+			r.RoundID,
+			r.Room,
+			r.Started.Format(time.RFC3339Nano),
+			r.Ended.Format(time.RFC3339Nano),
+			r.UID,
+			strconv.Itoa(r.Seat),
+			strconv.Itoa(r.Hand),
+			Hand{Cards: r.Cards}.String(),
+			Hand{Cards: r.Dealer}.String(),
+			strings.Join(actions, " "),
+			strconv.FormatInt(r.Bet, 10),
+			strconv.FormatInt(r.Payout, 10),
+			strconv.FormatInt(r.Net, 10),
+			string(r.Result),
+			strconv.FormatBool(r.Split),
+			strconv.FormatBool(r.Doubled),
+		})
+	}
+	out.Flush()
+}
@@ -0,0 +1,49 @@
+package game
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+)
+
+// opens a JSON lines file for appending, creating it if needed, and decodes every line already in it
+func openJSONLines[T any](path string) (*os.File, []T, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var values []T
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var v T
+		err := json.Unmarshal(scanner.Bytes(), &v)
+		if err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+		values = append(values, v)
+	}
+	if err := scanner.Err(); err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+
+	return f, values, nil
+}
+
+// writes values to the end of a JSON lines file in a single write
+func appendJSONLines[T any](f *os.File, values ...T) error {
+	var lines []byte
+	for _, v := range values {
+		line, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		lines = append(append(lines, line...), '\n')
+	}
+
+	_, err := f.Write(lines)
+	return err
+}
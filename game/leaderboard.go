@@ -0,0 +1,277 @@
+package game
+
+import (
+	"cmp"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+type boardPeriod string
+
+const (
+	PeriodAllTime boardPeriod = "all"
+	PeriodDaily   boardPeriod = "daily"   // resets at midnight UTC
+	PeriodWeekly  boardPeriod = "weekly"  // resets at midnight UTC between Sunday and Monday
+	PeriodSession boardPeriod = "session" // per room, since the room started
+)
+
+type boardMetric string
+
+const (
+	BoardBankroll   boardMetric = "bankroll"
+	BoardNet        boardMetric = "net"
+	BoardBiggestWin boardMetric = "biggestWin"
+)
+
+type handScores struct {
+	net        int64
+	biggestWin int64
+}
+
+// winnings of every player over one period
+type periodScores struct {
+	period boardPeriod
+	start  time.Time // start of the current period, zero for all time and sessions
+	scores map[string]*handScores
+}
+
+func newPeriodScores(period boardPeriod) *periodScores {
+	return &periodScores{period: period, scores: make(map[string]*handScores)}
+}
+
+// returns when the period containing t started
+func (p *periodScores) periodStart(t time.Time) time.Time {
+	day := t.UTC().Truncate(24 * time.Hour)
+	switch p.period {
+	case PeriodDaily:
+		return day
+	case PeriodWeekly:
+		return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	}
+	return time.Time{}
+}
+
+// clears the scores once a new period has begun
+func (p *periodScores) rollover(now time.Time) {
+	if start := p.periodStart(now); start.After(p.start) {
+		p.start = start
+		clear(p.scores)
+	}
+}
+
+func (p *periodScores) add(uid string, o handOutcome) {
+	p.rollover(o.Time)
+	if o.Time.Before(p.start) {
+		return
+	}
+
+	s, ok := p.scores[uid]
+	if !ok {
+		s = &handScores{}
+		p.scores[uid] = s
+	}
+	s.net += o.Net
+	s.biggestWin = max(s.biggestWin, o.Net)
+}
+
+type leaderboards struct {
+	periods []*periodScores
+	rooms   map[string]*periodScores
+	names   map[string]string
+	started time.Time // wallets are only known once loaded, so the bankroll board covers players seen since then
+	lock    sync.Mutex
+}
+
+func newLeaderboards() *leaderboards {
+	return &leaderboards{
+		periods: []*periodScores{newPeriodScores(PeriodAllTime), newPeriodScores(PeriodDaily), newPeriodScores(PeriodWeekly)},
+		rooms:   make(map[string]*periodScores),
+		names:   make(map[string]string),
+		started: time.Now(),
+	}
+}
+
+// adds a settled hand; room is empty for hands loaded from history, which only count towards the periodic boards
+func (b *leaderboards) add(room string, uid string, o handOutcome) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	for _, p := range b.periods {
+		p.add(uid, o)
+	}
+
+	if room != "" {
+		s, ok := b.rooms[room]
+		if !ok {
+			s = newPeriodScores(PeriodSession)
+			b.rooms[room] = s
+		}
+		s.add(uid, o)
+	}
+}
+
+// drops a closed room's board, so a new room given the same code starts from nothing
+func (b *leaderboards) forgetRoom(room string) {
+	b.lock.Lock()
+	delete(b.rooms, room)
+	b.lock.Unlock()
+}
+
+func (b *leaderboards) setName(uid string, displayName string) {
+	b.lock.Lock()
+	b.names[uid] = displayName
+	b.lock.Unlock()
+}
+
+type leaderboardEntry struct {
+	Rank        int    `json:"rank"`
+	UID         string `json:"playerId"`
+	DisplayName string `json:"displayName"`
+	Value       int64  `json:"value"`
+}
+
+type leaderboardResponse struct {
+	Board    boardMetric        `json:"board"`
+	Period   boardPeriod        `json:"period"`
+	Room     string             `json:"room,omitempty"`
+	Start    *time.Time         `json:"start,omitempty"` // when the current period started
+	Since    *time.Time         `json:"since,omitempty"` // bankroll: when the server started, only players seen since are on it
+	Entries  []leaderboardEntry `json:"entries"`
+	Page     int                `json:"page"`
+	PageSize int                `json:"pageSize"`
+	Total    int                `json:"total"`
+	Player   *leaderboardEntry  `json:"player"` // the requesting player's own entry, if they are on the board
+}
+
+// ranks values highest first; equal values share a rank
+func rankEntries(values map[string]int64, names map[string]string) []leaderboardEntry {
+	entries := make([]leaderboardEntry, 0, len(values))
+	for uid, v := range values {
+		entries = append(entries, leaderboardEntry{UID: uid, DisplayName: names[uid], Value: v})
+	}
+	slices.SortFunc(entries, func(a, b leaderboardEntry) int {
+		return cmp.Or(cmp.Compare(b.Value, a.Value), strings.Compare(a.UID, b.UID))
+	})
+
+	for i := range entries {
+		if i > 0 && entries[i].Value == entries[i-1].Value {
+			entries[i].Rank = entries[i-1].Rank
+		} else {
+			entries[i].Rank = i + 1
+		}
+	}
+	return entries
+}
+
+// ranked entries of a board, with the start of its current period
+func (server *server) leaderboard(metric boardMetric, period boardPeriod, room string) ([]leaderboardEntry, time.Time) {
+	values := make(map[string]int64)
+
+	server.boards.lock.Lock()
+	defer server.boards.lock.Unlock()
+
+	if metric == BoardBankroll {
+		server.playerLock.RLock()
+		for uid, money := range server.players {
+			values[uid] = money
+		}
+		server.playerLock.RUnlock()
+		return rankEntries(values, server.boards.names), time.Time{}
+	}
+
+	var p *periodScores
+	if room != "" {
+		p = server.boards.rooms[room]
+	} else {
+		i := slices.IndexFunc(server.boards.periods, func(p *periodScores) bool { return p.period == period })
+		p = server.boards.periods[i]
+	}
+	if p == nil {
+		return []leaderboardEntry{}, time.Time{}
+	}
+
+	p.rollover(time.Now())
+	for uid, s := range p.scores {
+		switch metric {
+		case BoardNet:
+			values[uid] = s.net
+		case BoardBiggestWin:
+			if s.biggestWin > 0 {
+				values[uid] = s.biggestWin
+			}
+		}
+	}
+	return rankEntries(values, server.boards.names), p.start
+}
+
+func (server *server) handleLeaderboardRequest(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		q := r.URL.Query()
+		metric := boardMetric(cmp.Or(q.Get("board"), string(BoardNet)))
+		period := boardPeriod(cmp.Or(q.Get("period"), string(PeriodAllTime)))
+		room := q.Get("room")
+
+		switch {
+		case metric != BoardBankroll && metric != BoardNet && metric != BoardBiggestWin:
+			writeJSON(w, http.StatusBadRequest, errorResponse{"board must be bankroll, net or biggestWin"})
+			return
+		case room != "" && metric == BoardBankroll:
+			writeJSON(w, http.StatusBadRequest, errorResponse{"bankroll has no per-room board"})
+			return
+		case room == "" && period != PeriodAllTime && period != PeriodDaily && period != PeriodWeekly:
+			writeJSON(w, http.StatusBadRequest, errorResponse{"period must be all, daily or weekly"})
+			return
+		}
+		if room != "" {
+			if _, ok := server.room(room); !ok {
+				writeJSON(w, http.StatusNotFound, errorResponse{"room not found"})
+				return
+			}
+			period = PeriodSession
+		}
+		if metric == BoardBankroll {
+			period = PeriodAllTime
+		}
+
+		page, pageSize, err := parsePage(q)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, errorResponse{err.Error()})
+			return
+		}
+
+		// players identify themselves with the same token they use on the websocket
+		var uid string
+		if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+			if !server.allowAuth(w, r) {
+				return
+			}
+			uid, _, err = server.auth.verify(r.Context(), token)
+			if err != nil {
+				authFailuresCounter.WithLabelValues("leaderboard").Inc()
+				writeJSON(w, http.StatusUnauthorized, errorResponse{"invalid token"})
+				return
+			}
+		}
+
+		entries, start := server.leaderboard(metric, period, room)
+		res := leaderboardResponse{Board: metric, Period: period, Room: room, Page: page, PageSize: pageSize, Total: len(entries)}
+		if !start.IsZero() {
+			res.Start = &start
+		}
+		if metric == BoardBankroll {
+			res.Since = &server.boards.started
+		}
+
+		first := min((page-1)*pageSize, len(entries))
+		res.Entries = entries[first:min(first+pageSize, len(entries))]
+		if i := slices.IndexFunc(entries, func(e leaderboardEntry) bool { return e.UID == uid }); uid != "" && i >= 0 {
+			res.Player = &entries[i]
+		}
+
+		writeJSON(w, http.StatusOK, res)
+	}
+}
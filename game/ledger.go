@@ -0,0 +1,267 @@
+package game
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// what moved a balance
+type moneyReason struct {
+	Kind   string // bet, double, split, refund, payout or the kind of grant
+	Actor  string // "table", "system", "admin" or the player themselves
+	Room   string
+	Round  string
+	Reason string // why a grant was given
+}
+
+func grantReason(kind grantKind, uid string, reason string) moneyReason {
+	actor := "system"
+	switch kind {
+	case GrantAdmin:
+		actor = "admin"
+	case GrantRebuy:
+		actor = uid
+	}
+	return moneyReason{Kind: string(kind), Actor: actor, Reason: reason}
+}
+
+// one balance change, chained to the entry before it so edits and gaps show up
+type ledgerEntry struct {
+	Seq    int64     `json:"seq"`
+	Time   time.Time `json:"time"`
+	UID    string    `json:"playerId"`
+	Actor  string    `json:"actor"`
+	Kind   string    `json:"kind"`
+	Amount int64     `json:"amount"`
+	Before int64     `json:"before"`
+	After  int64     `json:"after"`
+	Room   string    `json:"room,omitempty"`
+	Round  string    `json:"round,omitempty"`
+	Reason string    `json:"reason,omitempty"`
+	Prev   string    `json:"prev"` // hash of the entry before, empty for the first
+	Hash   string    `json:"hash"` // HMAC-SHA256 with the ledger key, or SHA-256 without one, over the entry without its hash
+}
+
+func (e ledgerEntry) sum(key []byte) string {
+	e.Hash = ""
+	data, _ := json.Marshal(e)
+
+	var h hash.Hash
+	if len(key) > 0 {
+		h = hmac.New(sha256.New, key)
+	} else {
+		h = sha256.New()
+	}
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// append-only, hash-chained record of every change to a real wallet
+type ledger struct {
+	key     []byte
+	file    *os.File // nil keeps only the head of the chain
+	seq     int64
+	head    string
+	entries chan ledgerEntry // written in order by a single goroutine, so callers never wait on the file
+	pending sync.WaitGroup
+}
+
+func newMemoryLedger(key []byte) *ledger {
+	return (&ledger{key: key}).start()
+}
+
+func (l *ledger) start() *ledger {
+	l.entries = make(chan ledgerEntry, 1024)
+	go l.write()
+	return l
+}
+
+// carries on the chain in the file at path from its last entry that verifies, lines that don't are skipped
+func newFileLedger(path string, key []byte) (*ledger, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	last, torn, err := lastVerifiedEntry(f, key)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	// a line cut off by a crash is left alone, new entries start on the next one
+	if torn {
+		if _, err := f.Write([]byte{'\n'}); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	l := &ledger{key: key, file: f, seq: last.Seq, head: last.Hash}
+
+	// a broken chain is logged and left for the verifier to point out, refusing to pay out would be worse
+	check, err := os.Open(path)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	defer check.Close()
+
+	report, err := VerifyLedger(check, key)
+	if err != nil {
+		slog.Error("error verifying ledger", "path", path, "err", err)
+	} else if len(report.Problems) > 0 {
+		p := report.Problems[0]
+		slog.Error("ledger does not verify", "path", path, "problems", len(report.Problems), "line", p.Line, "seq", p.Seq, "problem", p.Problem)
+	}
+	if report.Head != l.head {
+		slog.Warn("resuming ledger before its last line", "path", path, "seq", l.seq)
+	}
+	return l.start(), nil
+}
+
+// finds the last entry whose hash matches its contents, and whether the file ends partway through a line
+func lastVerifiedEntry(r io.Reader, key []byte) (ledgerEntry, bool, error) {
+	var last ledgerEntry
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			return last, len(line) > 0, nil
+		}
+		if err != nil {
+			return ledgerEntry{}, false, err
+		}
+
+		var e ledgerEntry
+		if json.Unmarshal(line, &e) == nil && e.Hash != "" && e.Hash == e.sum(key) {
+			last = e
+		}
+	}
+}
+
+// queues an entry to be chained on, entries are written in the order they are recorded
+func (l *ledger) record(e ledgerEntry) {
+	l.pending.Add(1)
+	l.entries <- e
+}
+
+func (l *ledger) write() {
+	for e := range l.entries {
+		e.Seq, e.Prev = l.seq+1, l.head
+		e.Hash = e.sum(l.key)
+		if l.file != nil {
+			l.append(e)
+		}
+		l.seq, l.head = e.Seq, e.Hash
+		l.pending.Done()
+	}
+}
+
+// writes an entry, retrying until the file takes it rather than leave a gap in the chain; while it's failing the
+// queue fills up and money stops moving. Each retry picks up where the failed write stopped so no line is left half written.
+func (l *ledger) append(e ledgerEntry) {
+	line, _ := json.Marshal(e)
+	line = append(line, '\n')
+
+	wait := 100 * time.Millisecond
+	for {
+		n, err := l.file.Write(line)
+		if err == nil {
+			return
+		}
+		line = line[n:]
+		// the whole entry goes in the log so it can be put back by hand if the file never recovers
+		slog.Error("error writing ledger, retrying", "entry", e, "retryIn", wait, "err", err)
+		time.Sleep(wait)
+		wait = min(2*wait, 10*time.Second)
+	}
+}
+
+// waits for every recorded entry to be written, false if giveUp comes first
+func (l *ledger) flush(giveUp <-chan struct{}) bool {
+	done := make(chan struct{})
+	go func() {
+		l.pending.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-giveUp:
+		return false
+	}
+}
+
+type LedgerProblem struct {
+	Line    int    `json:"line"`
+	Seq     int64  `json:"seq"`
+	Problem string `json:"problem"`
+}
+
+type LedgerReport struct {
+	Entries  int             `json:"entries"`
+	Head     string          `json:"head"` // hash of the last entry, keep it elsewhere to catch the end being cut off
+	Problems []LedgerProblem `json:"problems"`
+}
+
+// checks every entry's hash, that each links to the one before, that none are missing and that balances add up,
+// both within an entry and from one of a player's entries to their next
+func VerifyLedger(r io.Reader, key []byte) (LedgerReport, error) {
+	report := LedgerReport{Problems: []LedgerProblem{}}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+
+	var prev ledgerEntry
+	balances := make(map[string]int64) // each player's balance after their last entry
+	for line := 1; scanner.Scan(); line++ {
+		var e ledgerEntry
+		problem := func(format string, args ...any) {
+			report.Problems = append(report.Problems, LedgerProblem{line, e.Seq, fmt.Sprintf(format, args...)})
+		}
+
+		decoder := json.NewDecoder(bytes.NewReader(scanner.Bytes()))
+		decoder.DisallowUnknownFields()
+		err := decoder.Decode(&e)
+		if err != nil {
+			problem("unreadable entry: %v", err)
+			continue
+		}
+		report.Entries++
+
+		switch {
+		case e.Seq != prev.Seq+1:
+			problem("expected entry %d, found %d", prev.Seq+1, e.Seq)
+		case e.Prev != prev.Hash:
+			problem("does not link to the entry before")
+		}
+		if e.Hash != e.sum(key) {
+			problem("hash does not match contents")
+		}
+		if e.Before+e.Amount != e.After {
+			problem("%d + %d is not %d", e.Before, e.Amount, e.After)
+		}
+		// without a key the chain can be recomputed after an edit, but balances still have to follow on
+		if last, ok := balances[e.UID]; ok && e.Before != last {
+			problem("balance before is %d, the player's last entry left %d", e.Before, last)
+		}
+		balances[e.UID] = e.After
+		prev = e
+	}
+	if err := scanner.Err(); err != nil {
+		return report, err
+	}
+
+	report.Head = prev.Hash
+	return report, nil
+}
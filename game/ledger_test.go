@@ -0,0 +1,134 @@
+package game
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// a ledger file with a's 1000 going to 990 and back to 1010, then b's 1000 going to 980
+func writeTestLedger(t *testing.T, key []byte) []ledgerEntry {
+	path := filepath.Join(t.TempDir(), "ledger.jsonl")
+	l, err := newFileLedger(path, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range []ledgerEntry{
+		{UID: "a", Kind: "bet", Amount: -10, Before: 1000, After: 990},
+		{UID: "a", Kind: "payout", Amount: 20, Before: 990, After: 1010},
+		{UID: "b", Kind: "bet", Amount: -20, Before: 1000, After: 980},
+	} {
+		l.record(e)
+	}
+	l.flush(nil)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries []ledgerEntry
+	for _, line := range bytes.Split(bytes.TrimSpace(data), []byte{'\n'}) {
+		var e ledgerEntry
+		if err := json.Unmarshal(line, &e); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+func verifyEntries(t *testing.T, key []byte, entries []ledgerEntry) LedgerReport {
+	var b strings.Builder
+	for _, e := range entries {
+		line, _ := json.Marshal(e)
+		b.Write(append(line, '\n'))
+	}
+	report, err := VerifyLedger(strings.NewReader(b.String()), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return report
+}
+
+func TestVerifyLedger(t *testing.T) {
+	key := []byte("key")
+	entries := writeTestLedger(t, key)
+	if report := verifyEntries(t, key, entries); report.Entries != 3 || len(report.Problems) != 0 || report.Head != entries[2].Hash {
+		t.Fatalf("untouched ledger: %+v", report)
+	}
+
+	tests := []struct {
+		name    string
+		key     []byte
+		change  func([]ledgerEntry) []ledgerEntry
+		problem string
+	}{
+		{"tampered amount", key, func(es []ledgerEntry) []ledgerEntry {
+			es[1].Amount, es[1].After = 120, 1110
+			return es
+		}, "hash does not match contents"},
+		{"missing entry", key, func(es []ledgerEntry) []ledgerEntry {
+			return append(es[:1], es[2:]...)
+		}, "expected entry 2, found 3"},
+		// rechained without a key, so only the balances give it away
+		{"balance out of line", nil, func(es []ledgerEntry) []ledgerEntry {
+			es[1].Before, es[1].After = 1090, 1110
+			for i := range es {
+				if i > 0 {
+					es[i].Prev = es[i-1].Hash
+				}
+				es[i].Hash = es[i].sum(nil)
+			}
+			return es
+		}, "balance before is 1090, the player's last entry left 990"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := verifyEntries(t, tt.key, tt.change(writeTestLedger(t, tt.key)))
+			found := false
+			for _, p := range report.Problems {
+				found = found || p.Problem == tt.problem
+			}
+			if !found {
+				t.Errorf("got problems %+v, want %q", report.Problems, tt.problem)
+			}
+		})
+	}
+}
+
+func TestLedgerResumesAfterBadLines(t *testing.T) {
+	key := []byte("key")
+	path := filepath.Join(t.TempDir(), "ledger.jsonl")
+	l, err := newFileLedger(path, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.record(ledgerEntry{UID: "a", Kind: "bet", Amount: -10, Before: 1000, After: 990})
+	l.flush(nil)
+
+	// an unreadable line, then one cut off partway through by a crash
+	f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	f.WriteString("not json\n{\"seq\":2,\"playerId\":\"a\"")
+	f.Close()
+
+	l, err = newFileLedger(path, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.record(ledgerEntry{UID: "a", Kind: "payout", Amount: 20, Before: 990, After: 1010})
+	l.flush(nil)
+
+	f, _ = os.Open(path)
+	defer f.Close()
+	report, err := VerifyLedger(f, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the bad lines are reported, but the new entry chains on from the last good one
+	if report.Entries != 2 || len(report.Problems) != 2 || l.seq != 2 {
+		t.Errorf("got %d entries, problems %+v and seq %d, want 2 entries, 2 unreadable lines and seq 2", report.Entries, report.Problems, l.seq)
+	}
+}
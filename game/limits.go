@@ -0,0 +1,257 @@
+package game
+
+import (
+	"errors"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// how hard a client can push the server; rates are per second, times are in milliseconds, and 0 turns a limit off
+type limitsConfig struct {
+	MessageRate        float64 `json:"messageRate"` // websocket messages per connection
+	MessageBurst       int     `json:"messageBurst"`
+	PlayerMessageRate  float64 `json:"playerMessageRate"` // websocket messages across all of a player's connections
+	PlayerMessageBurst int     `json:"playerMessageBurst"`
+	CreateRate         float64 `json:"createRate"` // rooms created per address
+	CreateBurst        int     `json:"createBurst"`
+	AuthRate           float64 `json:"authRate"` // token checks per address, on websockets, rebuys, leaderboards and admin calls
+	AuthBurst          int     `json:"authBurst"`
+	EVRate             float64 `json:"evRate"` // expected value requests per address
+	EVBurst            int     `json:"evBurst"`
+	ConnectionsPerUID  int     `json:"connectionsPerUid"`
+	ConnectionsPerIP   int     `json:"connectionsPerIp"`
+	MaxMessageSize     int64   `json:"maxMessageSize"` // bytes, larger websocket messages close the connection
+	Strikes            int     `json:"strikes"`        // times a limit is hit within strikeWindow before a temporary ban
+	StrikeWindow       int64   `json:"strikeWindow"`
+	BanDuration        int64   `json:"banDuration"`
+}
+
+func (c limitsConfig) validate() error {
+	switch {
+	case c.MessageRate < 0 || c.PlayerMessageRate < 0 || c.CreateRate < 0 || c.AuthRate < 0 || c.EVRate < 0:
+		return errors.New("limits rates must not be negative")
+	case c.MessageRate > 0 && c.MessageBurst < 1 || c.PlayerMessageRate > 0 && c.PlayerMessageBurst < 1 ||
+		c.CreateRate > 0 && c.CreateBurst < 1 || c.AuthRate > 0 && c.AuthBurst < 1 || c.EVRate > 0 && c.EVBurst < 1:
+		return errors.New("limits bursts must be at least 1 where a rate is set")
+	case c.ConnectionsPerUID < 0 || c.ConnectionsPerIP < 0 || c.MaxMessageSize < 0:
+		return errors.New("limits connections and maxMessageSize must not be negative")
+	case c.Strikes < 0:
+		return errors.New("limits strikes must not be negative")
+	case c.Strikes > 0 && (c.StrikeWindow < 1 || c.BanDuration < 1):
+		return errors.New("limits strikeWindow and banDuration must be positive when strikes is set")
+	}
+	return nil
+}
+
+// token bucket refilling at rate tokens a second up to burst, not safe for concurrent use
+type bucket struct {
+	rate     float64
+	burst    float64
+	tokens   float64
+	lastSeen time.Time
+}
+
+func newBucket(rate float64, burst int) *bucket {
+	return &bucket{rate, float64(burst), float64(burst), time.Now()}
+}
+
+// whether the bucket has refilled, so forgetting it gives nothing away
+func (b *bucket) full(now time.Time) bool {
+	return b.tokens+now.Sub(b.lastSeen).Seconds()*b.rate >= b.burst
+}
+
+func (b *bucket) take() bool {
+	now := time.Now()
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.lastSeen).Seconds()*b.rate)
+	b.lastSeen = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// shared token buckets, connection counts and temporary bans, keyed by "uid:" or "ip:" and the uid or address
+type limits struct {
+	config  limitsConfig
+	buckets map[string]*bucket // also keyed by what is limited
+	conns   map[string]int
+	strikes map[string][]time.Time
+	bans    map[string]time.Time // when each ban ends
+	lock    sync.Mutex
+}
+
+// full buckets, old strikes and ended bans are forgotten this often
+const limitsSweepInterval = 10 * time.Minute
+
+func newLimits(config limitsConfig) *limits {
+	l := &limits{
+		config:  config,
+		buckets: make(map[string]*bucket),
+		conns:   make(map[string]int),
+		strikes: make(map[string][]time.Time),
+		bans:    make(map[string]time.Time),
+	}
+	go l.sweep()
+	return l
+}
+
+func (l *limits) sweep() {
+	for range time.Tick(limitsSweepInterval) {
+		now := time.Now()
+		l.lock.Lock()
+		for k, b := range l.buckets {
+			if b.full(now) {
+				delete(l.buckets, k)
+			}
+		}
+		for k, s := range l.strikes {
+			if now.Sub(s[len(s)-1]) > time.Duration(l.config.StrikeWindow)*time.Millisecond {
+				delete(l.strikes, k)
+			}
+		}
+		for k, until := range l.bans {
+			if now.After(until) {
+				delete(l.bans, k)
+			}
+		}
+		l.lock.Unlock()
+	}
+}
+
+// a bucket for one connection, nil if messages aren't limited per connection
+func (l *limits) connectionBucket() *bucket {
+	if l.config.MessageRate == 0 {
+		return nil
+	}
+	return newBucket(l.config.MessageRate, l.config.MessageBurst)
+}
+
+// takes a token from the shared bucket for what is being done by key, always true for limits that are off
+func (l *limits) allow(what string, key string, rate float64, burst int) bool {
+	if rate == 0 {
+		return true
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	k := what + "/" + key
+	b, ok := l.buckets[k]
+	if !ok {
+		b = newBucket(rate, burst)
+		l.buckets[k] = b
+	}
+	return b.take()
+}
+
+// counts a limit being hit against every key, banning keys that hit limits too often; true if any key is now banned
+func (l *limits) strike(keys ...string) bool {
+	if l.config.Strikes == 0 {
+		return false
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	now := time.Now()
+	window := time.Duration(l.config.StrikeWindow) * time.Millisecond
+	banned := false
+	for _, k := range keys {
+		if now.Before(l.bans[k]) {
+			continue
+		}
+
+		s := append(l.strikes[k], now)
+		for len(s) > 0 && now.Sub(s[0]) > window {
+			s = s[1:]
+		}
+		l.strikes[k] = s
+
+		if len(s) >= l.config.Strikes {
+			l.bans[k] = now.Add(time.Duration(l.config.BanDuration) * time.Millisecond)
+			delete(l.strikes, k)
+			slog.Warn("temporarily banned", "key", k, "strikes", len(s), "until", l.bans[k])
+			banned = true
+		}
+	}
+	return banned
+}
+
+// time left on the first of keys that is banned
+func (l *limits) banned(keys ...string) (time.Duration, bool) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	for _, k := range keys {
+		if left := time.Until(l.bans[k]); left > 0 {
+			return left, true
+		}
+	}
+	return 0, false
+}
+
+// takes a connection slot for key, false if it already has max connections; 0 max is unlimited
+func (l *limits) connect(key string, max int) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if max > 0 && l.conns[key] >= max {
+		return false
+	}
+	l.conns[key]++
+	return true
+}
+
+func (l *limits) disconnect(key string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.conns[key]--
+	if l.conns[key] <= 0 {
+		delete(l.conns, key)
+	}
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+func uidKey(uid string) string {
+	return "uid:" + uid
+}
+
+func writeRateLimited(w http.ResponseWriter, retry time.Duration, reason string) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retry.Seconds()))))
+	writeJSON(w, http.StatusTooManyRequests, errorResponse{reason})
+}
+
+// checks a request's address isn't banned and may try another token, writing a 429 if not
+func (server *server) allowAuth(w http.ResponseWriter, r *http.Request) bool {
+	ip := ipKey(clientIP(r))
+	if left, ok := server.limits.banned(ip); ok {
+		writeRateLimited(w, left, "temporarily banned")
+		return false
+	}
+
+	c := server.config.Limits
+	if !server.limits.allow("auth", ip, c.AuthRate, c.AuthBurst) {
+		server.limits.strike(ip)
+		writeRateLimited(w, time.Duration(float64(time.Second)/c.AuthRate), "too many attempts")
+		return false
+	}
+	return true
+}
@@ -0,0 +1,55 @@
+package game
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBucketRefills(t *testing.T) {
+	b := newBucket(2, 3)
+	for i := range 3 {
+		if !b.take() {
+			t.Fatalf("take %d of a full burst of 3 refused", i+1)
+		}
+	}
+	if b.take() || b.full(b.lastSeen) {
+		t.Fatal("empty bucket gave a token or counts as full")
+	}
+
+	// half a second back at 2 tokens a second is one token
+	b.lastSeen = b.lastSeen.Add(-500 * time.Millisecond)
+	if b.full(time.Now()) || !b.take() || b.take() {
+		t.Error("bucket didn't refill exactly one token in half a second")
+	}
+
+	b.lastSeen = b.lastSeen.Add(-2 * time.Second)
+	if !b.full(time.Now()) {
+		t.Error("bucket isn't full after refilling for longer than a burst takes")
+	}
+}
+
+func TestStrikesBanUntilBanEnds(t *testing.T) {
+	l := newLimits(limitsConfig{Strikes: 3, StrikeWindow: 60_000, BanDuration: 50})
+	ip, uid := ipKey("10.0.0.1"), uidKey("a")
+
+	for i := range 2 {
+		if l.strike(ip, uid) {
+			t.Fatalf("banned after %d strikes, want 3", i+1)
+		}
+	}
+	if !l.strike(ip) {
+		t.Fatal("not banned after the third strike")
+	}
+	if _, ok := l.banned(ip); !ok {
+		t.Fatal("address isn't banned")
+	}
+	// only the address struck out
+	if _, ok := l.banned(uid); ok {
+		t.Error("player banned after 2 strikes")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if left, ok := l.banned(ip, uid); ok {
+		t.Errorf("still banned for %v after the ban ended", left)
+	}
+}
@@ -0,0 +1,70 @@
+package game
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+)
+
+type logConfig struct {
+	Level  string   `json:"level"`  // debug, info, warn or error
+	Format string   `json:"format"` // text or json
+	Redact []string `json:"redact"` // more attribute keys whose values are never written, on top of tokens, secrets and passwords
+}
+
+func (c logConfig) level() (slog.Level, error) {
+	var l slog.Level
+	err := l.UnmarshalText([]byte(c.Level))
+	return l, err
+}
+
+func (c logConfig) validate() error {
+	if _, err := c.level(); err != nil {
+		return errors.New("log level must be debug, info, warn or error")
+	}
+	if c.Format != "text" && c.Format != "json" {
+		return errors.New("log format must be text or json")
+	}
+	return nil
+}
+
+func (c logConfig) sensitive(key string) bool {
+	k := strings.ToLower(key)
+	return strings.Contains(k, "token") || strings.Contains(k, "secret") || strings.Contains(k, "password") ||
+		k == "authorization" || k == "cookie" || slices.ContainsFunc(c.Redact, func(r string) bool { return strings.EqualFold(r, key) })
+}
+
+func newLogger(w io.Writer, c logConfig) *slog.Logger {
+	level, _ := c.level()
+	opts := &slog.HandlerOptions{
+		Level: level,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if c.sensitive(a.Key) {
+				return slog.String(a.Key, "[redacted]")
+			}
+			return a
+		},
+	}
+
+	if c.Format == "json" {
+		return slog.New(slog.NewJSONHandler(w, opts))
+	}
+	return slog.New(slog.NewTextHandler(w, opts))
+}
+
+// logs at error level and exits, for failures the server can't run with
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// the room's logger with the ID of the round in progress, only called from the table's goroutine
+func (room *room) roundLog() *slog.Logger {
+	if room.table.round == nil {
+		return room.log
+	}
+	return room.log.With("round", room.table.round.ID)
+}
@@ -0,0 +1,155 @@
+package game
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	roomsGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "blackjack_rooms",
+		Help: "Rooms currently open.",
+	})
+	clientsGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "blackjack_connected_clients",
+		Help: "Websocket connections by room.",
+	}, []string{"room"})
+	seatedGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "blackjack_seated_players",
+		Help: "Players holding a seat by room.",
+	}, []string{"room"})
+	spectatorsGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "blackjack_spectators",
+		Help: "Connected players without a seat by room.",
+	}, []string{"room"})
+
+	roundsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "blackjack_rounds_dealt_total",
+		Help: "Rounds dealt by room.",
+	}, []string{"room"})
+	actionsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "blackjack_actions_total",
+		Help: "Player commands by room and action.",
+	}, []string{"room", "action"})
+	timeoutsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "blackjack_timeouts_total",
+		Help: "Betting and move timeouts by room and phase.",
+	}, []string{"room", "phase"})
+	houseCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "blackjack_house_money_total",
+		Help: "Money the house won or lost on settled hands by room, excluding bots and training rooms.",
+	}, []string{"room", "result"})
+	authFailuresCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "blackjack_auth_failures_total",
+		Help: "Rejected player tokens and admin tokens by endpoint.",
+	}, []string{"endpoint"})
+
+	decisionSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "blackjack_decision_seconds",
+		Help:    "Time from a hand's turn starting to each play by a player.",
+		Buckets: []float64{0.25, 0.5, 1, 2, 3, 5, 10, 20, 30, 60},
+	}, []string{"room"})
+	fanOutSeconds = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "blackjack_broadcast_fanout_seconds",
+		Help:    "Time to write one message to every connection in a room.",
+		Buckets: prometheus.ExponentialBuckets(0.0001, 4, 8),
+	})
+	walletWriteSeconds = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "blackjack_wallet_write_seconds",
+		Help:    "Time to save a balance to the wallet store.",
+		Buckets: prometheus.ExponentialBuckets(0.001, 3, 8),
+	})
+)
+
+// metrics for one live table; nil for replayed and simulated tables so nothing is counted twice
+type tableMetrics struct {
+	room      string
+	rounds    prometheus.Counter
+	decisions prometheus.Observer
+}
+
+func newTableMetrics(room string) *tableMetrics {
+	return &tableMetrics{room, roundsCounter.WithLabelValues(room), decisionSeconds.WithLabelValues(room)}
+}
+
+func (m *tableMetrics) roundDealt() {
+	if m == nil {
+		return
+	}
+	m.rounds.Inc()
+}
+
+// actions players can send; anything else is counted as "other" so clients can't create new series
+var knownActions = map[string]bool{
+	"join": true, "leave": true, "sitOut": true, "sitIn": true, "autoBet": true, "report": true,
+	"bet": true, "rebet": true, "rebetAndDeal": true,
+	"hit": true, "stand": true, "double": true, "split": true, "surrender": true, "timeBank": true, "hint": true,
+}
+
+func (m *tableMetrics) action(action string) {
+	if m == nil {
+		return
+	}
+	if !knownActions[action] {
+		action = "other"
+	}
+	actionsCounter.WithLabelValues(m.room, action).Inc()
+}
+
+func (m *tableMetrics) timeout(status tableStatus) {
+	if m == nil {
+		return
+	}
+	timeoutsCounter.WithLabelValues(m.room, status.String()).Inc()
+}
+
+func (m *tableMetrics) decision(seconds float64) {
+	if m == nil {
+		return
+	}
+	m.decisions.Observe(seconds)
+}
+
+// the house wins what the player loses
+func (m *tableMetrics) settled(net int64) {
+	switch {
+	case m == nil:
+	case net < 0:
+		houseCounter.WithLabelValues(m.room, "won").Add(float64(-net))
+	case net > 0:
+		houseCounter.WithLabelValues(m.room, "lost").Add(float64(net))
+	}
+}
+
+// sets the room's seat gauges from the table, called from the table's goroutine
+func (room *room) updateGauges() {
+	t := &room.table
+	seated := make(map[string]bool)
+	for _, h := range t.Hands {
+		if h.PlayerUID != "" {
+			seated[h.PlayerUID] = true
+		}
+	}
+
+	spectators := 0
+	for _, p := range t.Players {
+		if p.active && !seated[p.UID] {
+			spectators++
+		}
+	}
+	seatedGauge.WithLabelValues(room.code).Set(float64(len(seated)))
+	spectatorsGauge.WithLabelValues(room.code).Set(float64(spectators))
+}
+
+// drops every series of a room that has closed
+func forgetRoomMetrics(code string) {
+	labels := prometheus.Labels{"room": code}
+	clientsGauge.DeletePartialMatch(labels)
+	seatedGauge.DeletePartialMatch(labels)
+	spectatorsGauge.DeletePartialMatch(labels)
+	roundsCounter.DeletePartialMatch(labels)
+	actionsCounter.DeletePartialMatch(labels)
+	timeoutsCounter.DeletePartialMatch(labels)
+	houseCounter.DeletePartialMatch(labels)
+	decisionSeconds.DeletePartialMatch(labels)
+}
@@ -3,6 +3,7 @@ package game
 import (
 	"encoding/json"
 	"slices"
+	"sync/atomic"
 	"time"
 )
 
@@ -14,61 +15,114 @@ const (
 	DealerTurn
 )
 
+func (s tableStatus) String() string {
+	switch s {
+	case Betting:
+		return "betting"
+	case PlayerTurn:
+		return "playerTurn"
+	default:
+		return "dealerTurn"
+	}
+}
+
+type timeoutAction string
+
+// what happens to the active hand when its player runs out of time
+const (
+	TimeoutStand         timeoutAction = "stand"
+	TimeoutBasicStrategy timeoutAction = "basicStrategy"
+	TimeoutSurrender     timeoutAction = "surrender"
+)
+
 type player struct {
-	UID         string `json:"id"`
-	DisplayName string `json:"displayName"`
-	Money       int64  `json:"money"`
+	UID         string        `json:"id"`
+	DisplayName string        `json:"displayName"`
+	Money       int64         `json:"money"`
+	TimeBank    int64         `json:"timeBank"` // milliseconds
+	Stats       *statsSummary `json:"stats,omitempty"`
+	Bot         bool          `json:"bot,omitempty"`
 	active      bool
+	timeBank    time.Duration
+	training    trainingReport
+	flagged     bool // bets have been tracking the count
 }
 
 type table struct {
-	deck                  Deck
-	dealer                Hand
-	minBet                int64
-	seats                 int
-	status                tableStatus
-	Players               []player
-	Hands                 []Hand
-	ActiveHand            int
-	actionTimeStart       time.Time
-	beginBettingTimeLimit bool
-	moveTimeLimit         time.Duration
-	bettingTimeLimit      time.Duration
-	Broadcast             chan []byte
-	getMoney              func(string) int64
-	deltaMoney            func(string, int64)
-}
-
-func newTable(broadcast chan []byte, seats int, getMoney func(string) int64, deltaMoney func(string, int64)) table {
-	deck := makeDeck(1)
+	deck              Deck
+	dealer            Hand
+	minBet            int64
+	maxBet            int64
+	seats             int
+	decks             int
+	penetration       float64
+	rules             tableRules
+	status            tableStatus
+	Players           []player
+	Hands             []Hand
+	ActiveHand        int
+	actionTimeStart   time.Time
+	timeBankInUse     time.Duration
+	draining          bool
+	maxMissedRounds   int
+	moveTimeLimit     time.Duration
+	bettingTimeLimit  time.Duration
+	timeBank          time.Duration
+	timeoutAction     timeoutAction
+	hints             bool
+	training          bool
+	bots              []botPersonality
+	botsYield         bool
+	countermeasures   countermeasures
+	Broadcast         chan []byte
+	sendPrivate       func(string, []byte) // nil drops private messages
+	getMoney          func(string) int64
+	deltaMoney        func(string, int64, moneyReason)
+	getStats          func(string) statsSummary // nil leaves stats out of broadcasts
+	handSettled       func(string, handOutcome)
+	betPlaced         func(string, countSample)
+	now               time.Time // time of the event being handled
+	round             *roundLog
+	roundDone         func(roundLog)
+	replayShuffles    [][]card         // shoe orders to reuse when replaying a round, nil when live
+	balances          map[string]int64 // balances of everyone at the table after the last event, to spot changes made elsewhere
+	headless          bool             // no broadcasts or round logs, for simulations
+	metrics           *tableMetrics
+	broadcastsWaiting *atomic.Int64 // counts the table blocked on Broadcast, nil outside live rooms
+}
+
+func newTable(broadcast chan []byte, config tableConfig, getMoney func(string) int64, deltaMoney func(string, int64, moneyReason)) table {
+	deck := makeDeck(config.Decks)
 	deck.shuffle()
 
 	t := table{
-		deck:                  deck,
-		dealer:                Hand{},
-		minBet:                10,
-		seats:                 seats,
-		status:                Betting,
-		Players:               []player{},
-		Hands:                 make([]Hand, seats),
-		actionTimeStart:       time.Now(),
-		beginBettingTimeLimit: false,
-		moveTimeLimit:         5 * time.Second,
-		bettingTimeLimit:      15 * time.Second,
-		ActiveHand:            -1,
-		Broadcast:             broadcast,
-		getMoney:              getMoney,
-		deltaMoney:            deltaMoney,
+		deck:            deck,
+		dealer:          Hand{},
+		seats:           config.Seats,
+		decks:           config.Decks,
+		status:          Betting,
+		Players:         []player{},
+		Hands:           make([]Hand, config.Seats),
+		actionTimeStart: time.Now(),
+		now:             time.Now().Round(0),
+		ActiveHand:      -1,
+		Broadcast:       broadcast,
+		getMoney:        getMoney,
+		deltaMoney:      deltaMoney,
 	}
+	t.applyConfig(config)
 
 	return t
 }
 
 func (t *table) resetHands() {
+	t.recordOutcome()
+
 	t.ActiveHand = -1
 	t.status = Betting
 	t.dealer = Hand{}
-	t.beginBettingTimeLimit = false
+
+	t.updateLossStreaks()
 
 	// reset hands and remove split hands
 	h := 0
@@ -76,6 +130,9 @@ func (t *table) resetHands() {
 		if !x.Split {
 			x.Cards = nil
 			x.Bet = 0
+			x.net = 0
+			x.doubled = false
+			x.fromSplit = false
 			t.Hands[h] = x
 			h++
 		}
@@ -99,11 +156,26 @@ func (t *table) resetHands() {
 		}
 	}
 
+	if t.penetration > 0 && float64(t.deck.index) >= t.penetration*float64(len(t.deck.cards)) {
+		t.reshuffle()
+	}
+
 	t.broadcast()
+	t.endRound()
+	t.beginRound()
+}
+
+// moves a player's money as part of play
+func (t *table) moveMoney(uid string, delta int64, kind string) {
+	t.deltaMoney(uid, delta, moneyReason{Kind: kind, Actor: "table", Round: t.roundID()})
 }
 
 // call this method to broadcast table status to all players
 func (t *table) broadcast() {
+	if t.headless {
+		return
+	}
+
 	var d []card
 
 	// only show dealer's first card during player turn
@@ -115,6 +187,11 @@ func (t *table) broadcast() {
 
 	for i := range t.Players {
 		t.Players[i].Money = t.getMoney(t.Players[i].UID)
+		t.Players[i].TimeBank = t.Players[i].timeBank.Milliseconds()
+		if t.getStats != nil && !t.Players[i].Bot {
+			stats := t.getStats(t.Players[i].UID)
+			t.Players[i].Stats = &stats
+		}
 	}
 
 	out, _ := json.Marshal(broadcast{
@@ -124,8 +201,27 @@ func (t *table) broadcast() {
 		ActiveHand:  t.ActiveHand,
 		TableStatus: t.status,
 		Time:        t.actionTimeStart.UnixMilli(),
+		Deadline:    t.actionDeadline().UnixMilli(),
+		Closing:     t.draining,
 	})
-	t.Broadcast <- out
+	t.recordBroadcast(out)
+	if t.Broadcast != nil {
+		if t.broadcastsWaiting != nil {
+			t.broadcastsWaiting.Add(1)
+			defer t.broadcastsWaiting.Add(-1)
+		}
+		t.Broadcast <- out
+	}
+}
+
+// sends a message to every connection of one player
+func (t *table) tell(uid string, m privateMessage) {
+	if t.sendPrivate == nil {
+		return
+	}
+
+	out, _ := json.Marshal(m)
+	t.sendPrivate(uid, out)
 }
 
 func (t *table) playerWithUID(uid string) *player {
@@ -157,35 +253,100 @@ func (t *table) leave(uid string, seat int) {
 
 	t.Hands[seat] = Hand{}
 	t.broadcast()
+
+	// leaving the last seat ends the session
+	if !slices.ContainsFunc(t.Hands, func(h Hand) bool { return h.PlayerUID == uid }) {
+		t.endSession(uid)
+	}
+}
+
+// marks seat as sitting out; seat is kept but skipped until player sits back in. A seat with a bet in plays the round out first
+func (t *table) sitOut(uid string, seat int) {
+	if seat < 0 || seat >= t.seats || t.Hands[seat].PlayerUID != uid || t.Hands[seat].SittingOut || t.Hands[seat].Bet > 0 {
+		return
+	}
+
+	t.Hands[seat].SittingOut = true
+	t.broadcast()
+}
+
+func (t *table) sitIn(uid string, seat int) {
+	if seat < 0 || seat >= t.seats || t.Hands[seat].PlayerUID != uid || !t.Hands[seat].SittingOut {
+		return
+	}
+
+	t.Hands[seat].SittingOut = false
+	t.Hands[seat].missedRounds = 0
+	t.broadcast()
+}
+
+// counts a missed round for every seat sitting out and releases seats that have missed too many
+func (t *table) countMissedRounds() {
+	for i := range t.Hands {
+		h := &t.Hands[i]
+		if h.PlayerUID == "" || !h.SittingOut || h.Bet > 0 {
+			continue
+		}
+
+		h.missedRounds++
+		if h.missedRounds >= t.maxMissedRounds {
+			t.Hands[i] = Hand{}
+		}
+	}
 }
 
 func (t *table) enterBet(uid string, bet int64, seat int) {
 	player := t.playerWithUID(uid)
 
-	if player == nil || bet < t.minBet || bet > t.getMoney(uid) || seat < 0 || seat >= t.seats || t.Hands[seat].PlayerUID != uid || t.Hands[seat].Bet > 0 {
+	if player == nil || t.draining || bet < t.minBet || (t.maxBet > 0 && bet > t.maxBet) || bet > t.getMoney(uid) || seat < 0 || seat >= t.seats || t.Hands[seat].PlayerUID != uid || t.Hands[seat].SittingOut || t.Hands[seat].Bet > 0 || t.overBetCap(uid, bet) {
 		return
 	}
 
-	// set flag for main game loop to start betting time limit once someone has put an initial bet in
+	// betting time limit starts once someone has put an initial bet in
 	if !t.someBetsIn() {
-		t.beginBettingTimeLimit = true
-		t.actionTimeStart = time.Now()
+		t.actionTimeStart = t.now
 	}
 
-	t.deltaMoney(uid, -bet)
+	t.moveMoney(uid, -bet, "bet")
 	t.Hands[seat].Bet = bet
+	t.Hands[seat].LastBet = bet
+	if t.betPlaced != nil {
+		t.betPlaced(uid, countSample{bet, t.runningCount(), t.trueCount()})
+	}
 
 	t.broadcast()
 }
 
+// stops new rounds from starting; bets placed for a round that hasn't been dealt yet are refunded
+func (t *table) startDraining() {
+	t.draining = true
+	if t.status == Betting {
+		t.refundBets()
+	} else {
+		t.broadcast()
+	}
+}
+
+// gives back every bet still on the table and ends the round
+func (t *table) refundBets() {
+	for i := range t.Hands {
+		if t.Hands[i].Bet > 0 {
+			t.record(roundEvent{Type: EventRefund, UID: t.Hands[i].PlayerUID, Hand: i, Bet: t.Hands[i].Bet})
+			t.moveMoney(t.Hands[i].PlayerUID, t.Hands[i].Bet, "refund")
+			t.Hands[i].Bet = 0
+		}
+	}
+	t.resetHands()
+}
+
 func (t *table) dealAll() {
 	for range 2 {
 		for i := range t.Hands {
-			if t.Hands[i].PlayerUID != "" {
-				t.deck.dealTo(&t.Hands[i])
+			if t.Hands[i].PlayerUID != "" && t.Hands[i].Bet > 0 {
+				t.dealTo(&t.Hands[i])
 			}
 		}
-		t.deck.dealTo(&t.dealer)
+		t.dealTo(&t.dealer)
 	}
 }
 
@@ -214,7 +375,7 @@ func (t *table) allBetsIn() bool {
 
 	for i := range t.Hands {
 		// return false if someone claimed a seat and hasn't finished betting
-		if t.Hands[i].PlayerUID != "" && t.Hands[i].Bet == 0 {
+		if t.Hands[i].PlayerUID != "" && !t.Hands[i].SittingOut && t.Hands[i].Bet == 0 {
 			return false
 		}
 
@@ -229,7 +390,10 @@ func (t *table) allBetsIn() bool {
 
 func (t *table) startPlayerTurn() {
 	t.status = PlayerTurn
+	t.countMissedRounds()
+	t.shuffleAgainstCounters()
 	t.dealAll()
+	t.metrics.roundDealt()
 	if t.dealer.hasBlackjack() {
 		t.dealerTurn()
 	} else {
@@ -240,26 +404,28 @@ func (t *table) startPlayerTurn() {
 func (t *table) dealerTurn() {
 	t.status = DealerTurn
 	t.broadcast()
-	for !t.dealer.hasBust() && t.dealer.bestScore() < 17 {
-		t.deck.dealTo(&t.dealer)
+	for !t.dealer.hasBust() && (t.dealer.bestScore() < 17 || (t.rules.DealerHitsSoft17 && t.dealer.bestScore() == 17 && t.dealer.isSoft())) {
+		t.dealTo(&t.dealer)
 		t.broadcast()
 	}
 
 	d := t.dealer.bestScore()
 	for i, h := range t.Hands {
-		if h.PlayerUID == "" {
+		// busted and surrendered hands were settled already
+		if h.PlayerUID == "" || h.Bet == 0 {
 			continue
 		}
 
-		p := t.playerWithUID(h.PlayerUID)
-
-		if h.bestScore() > d {
-			t.deltaMoney(p.UID, 2*h.Bet)
+		payout, result := int64(0), ResultLoss
+		if h.hasBust() {
+			result = ResultBust
+		} else if h.bestScore() > d {
+			payout, result = 2*h.Bet, ResultWin
 		} else if h.bestScore() == d {
-			t.deltaMoney(p.UID, h.Bet)
+			payout, result = h.Bet, ResultPush
 		}
 
-		t.Hands[i].Bet = 0
+		t.settle(&t.Hands[i], payout, result)
 		t.broadcast()
 	}
 
@@ -268,20 +434,20 @@ func (t *table) dealerTurn() {
 
 // deals a card to the current hand
 func (t *table) hit() bool {
-	t.deck.dealTo(t.currentHand())
+	t.dealTo(t.currentHand())
 	return true
 }
 
 // returns whether current hand can be doubled
 func (t *table) canDouble() bool {
 	hand := t.currentHand()
-	return t.getMoney(hand.PlayerUID) >= hand.Bet
+	return (!hand.Split || t.rules.DoubleAfterSplit) && t.getMoney(hand.PlayerUID) >= hand.Bet
 }
 
 // returns whether current hand can be split
 func (t *table) canSplit() bool {
 	hand := t.currentHand()
-	return t.canDouble() && len(hand.Cards) == 2 && hand.Cards[0].value() == hand.Cards[1].value()
+	return t.getMoney(hand.PlayerUID) >= hand.Bet && len(hand.Cards) == 2 && hand.Cards[0].value() == hand.Cards[1].value()
 }
 
 // attempts to double current hand, returns whether double was successful
@@ -291,8 +457,9 @@ func (t *table) double() bool {
 
 	if t.canDouble() {
 		t.hit()
-		t.deltaMoney(player.UID, -hand.Bet)
+		t.moveMoney(player.UID, -hand.Bet, "double")
 		hand.Bet *= 2
+		hand.doubled = true
 		return true
 	}
 	return false
@@ -303,16 +470,94 @@ func (t *table) split() bool {
 	oldHand := t.currentHand()
 
 	if t.canSplit() {
-		newHand := Hand{Cards: []card{oldHand.Cards[1]}, Bet: oldHand.Bet, PlayerUID: oldHand.PlayerUID, Split: true}
+		newHand := Hand{Cards: []card{oldHand.Cards[1]}, Bet: oldHand.Bet, PlayerUID: oldHand.PlayerUID, Split: true, fromSplit: true}
 		oldHand.Cards = oldHand.Cards[:1]
+		oldHand.fromSplit = true
+		t.moveMoney(newHand.PlayerUID, -newHand.Bet, "split")
 		t.Hands = slices.Insert(t.Hands, t.ActiveHand+1, newHand)
 		return true
 	}
 	return false
 }
 
+// attempts to surrender current hand for half its bet back, returns whether surrender was successful
+func (t *table) surrender() bool {
+	hand := t.currentHand()
+
+	if t.canSurrender() {
+		t.settle(hand, hand.Bet/2, ResultSurrender)
+		return true
+	}
+	return false
+}
+
+// returns whether current hand can be surrendered (first two cards, not from a split)
+func (t *table) canSurrender() bool {
+	hand := t.currentHand()
+	return t.rules.Surrender && len(hand.Cards) == 2 && !hand.Split
+}
+
+// plays out the current hand using basic strategy
+func (t *table) playBasicStrategy() {
+	for {
+		switch t.recommendedMove() {
+		case Hit:
+			t.hit()
+			if t.bust() || t.currentHand().bestScore() == 21 {
+				return
+			}
+		case Double:
+			t.double()
+			t.bust()
+			return
+		case Split:
+			t.split()
+		case Surrender:
+			t.surrender()
+			return
+		default:
+			return
+		}
+	}
+}
+
+// returns when the current action times out, including any time bank being spent
+func (t *table) actionDeadline() time.Time {
+	switch t.status {
+	case Betting:
+		return t.actionTimeStart.Add(t.bettingTimeLimit)
+	default:
+		return t.actionTimeStart.Add(t.moveTimeLimit + t.timeBankInUse)
+	}
+}
+
+// spends the active player's time bank to extend their deadline
+func (t *table) useTimeBank(uid string) {
+	p := t.playerWithUID(uid)
+	if p == nil || t.currentHand().PlayerUID != uid || p.timeBank <= 0 {
+		return
+	}
+
+	t.timeBankInUse += p.timeBank
+	p.timeBank = 0
+	t.broadcast()
+}
+
+// starts a fresh move timer, giving back any time bank the active player didn't use
+func (t *table) restartActionTimer() {
+	if t.timeBankInUse > 0 && t.ActiveHand >= 0 && t.ActiveHand < len(t.Hands) {
+		if p := t.playerWithUID(t.currentHand().PlayerUID); p != nil {
+			p.timeBank += min(t.timeBankInUse, max(t.actionDeadline().Sub(t.now), 0))
+		}
+	}
+
+	t.timeBankInUse = 0
+	t.actionTimeStart = t.now
+}
+
 // advances active hand as far as possible
 func (table *table) advanceHand() {
+	table.restartActionTimer()
 	table.ActiveHand++
 
 	// skip past empty slots
@@ -321,14 +566,14 @@ func (table *table) advanceHand() {
 	}
 
 	// new player's turn is active
-	table.actionTimeStart = time.Now()
+	table.actionTimeStart = table.now
 	table.broadcast()
 
 	if table.ActiveHand >= len(table.Hands) {
 		table.dealerTurn()
 	} else {
-		// check for bust/blackjack and then skip for inactive players
-		if table.bust() || table.blackjack() || !table.playerWithUID(table.Hands[table.ActiveHand].PlayerUID).active {
+		// check for bust/blackjack and then skip for inactive or sitting out players
+		if table.bust() || table.blackjack() || table.currentHand().SittingOut || !table.playerWithUID(table.Hands[table.ActiveHand].PlayerUID).active {
 			table.advanceHand()
 		}
 	}
@@ -338,7 +583,7 @@ func (table *table) advanceHand() {
 // returns whether bust was detected
 func (t *table) bust() bool {
 	if t.currentHand().hasBust() {
-		t.currentHand().Bet = 0
+		t.settle(t.currentHand(), 0, ResultBust)
 		return true
 	}
 	return false
@@ -348,12 +593,26 @@ func (t *table) bust() bool {
 // returns whether blackjack was detected
 func (t *table) blackjack() bool {
 	hand := t.currentHand()
-	player := t.playerWithUID(hand.PlayerUID)
 
 	if hand.hasBlackjack() {
-		t.deltaMoney(player.UID, 5*hand.Bet/2)
-		hand.Bet = 0
+		t.settle(hand, t.rules.blackjackPayout(hand.Bet), ResultBlackjack)
 		return true
 	}
 	return false
 }
+
+// pays out a hand, clears its bet and records its net result for the round
+func (t *table) settle(h *Hand, payout int64, result handResult) {
+	t.record(roundEvent{Type: EventSettle, UID: h.PlayerUID, Hand: t.handIndex(h), Bet: h.Bet, Payout: payout, Result: result})
+	if payout > 0 {
+		t.moveMoney(h.PlayerUID, payout, "payout")
+	}
+	if !t.training && !isBot(h.PlayerUID) {
+		t.metrics.settled(payout - h.Bet)
+	}
+	if t.handSettled != nil {
+		t.handSettled(h.PlayerUID, handOutcome{result, payout - h.Bet, h.doubled, h.fromSplit, t.now})
+	}
+	h.net += payout - h.Bet
+	h.Bet = 0
+}
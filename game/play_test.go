@@ -0,0 +1,77 @@
+package game
+
+import "testing"
+
+func TestSplitChargesSecondBet(t *testing.T) {
+	// a: 8 8, dealer: 10 7, then a 10 on each split hand
+	tt := newTestTable(stackShoe(
+		card{Spade, Eight}, card{Heart, Ten}, card{Heart, Eight}, card{Heart, Seven},
+		card{Spade, King}, card{Diamond, Ten},
+	), "a")
+
+	tt.command("a", playerCommand{Action: "bet", Bet: 10, Seat: 0})
+	tt.command("a", playerCommand{Action: "split"})
+	if tt.money["a"] != 980 {
+		t.Fatalf("a has %d after splitting, want 980", tt.money["a"])
+	}
+
+	tt.command("a", playerCommand{Action: "hit"})
+	tt.command("a", playerCommand{Action: "stand"})
+	tt.command("a", playerCommand{Action: "hit"})
+	tt.command("a", playerCommand{Action: "stand"})
+
+	// both 18s beat the dealer's 17
+	if len(tt.rounds) != 1 || tt.money["a"] != 1020 {
+		t.Errorf("a has %d after %d rounds, want 1020 after 1", tt.money["a"], len(tt.rounds))
+	}
+}
+
+func TestBustedHandsSettleOnce(t *testing.T) {
+	// a: 10 6 hits a king, b: 9 3 doubles into a 10, dealer: 10 6 draws a queen
+	tt := newTestTable(stackShoe(
+		card{Spade, Ten}, card{Spade, Nine}, card{Heart, Ten}, card{Spade, Six},
+		card{Spade, Three}, card{Heart, Six}, card{Spade, King}, card{Diamond, Ten}, card{Heart, Queen},
+	), "a", "b")
+
+	tt.command("a", playerCommand{Action: "bet", Bet: 10, Seat: 0})
+	tt.command("b", playerCommand{Action: "bet", Bet: 10, Seat: 1})
+	tt.command("a", playerCommand{Action: "hit"})
+	tt.command("b", playerCommand{Action: "double"})
+
+	if len(tt.rounds) != 1 {
+		t.Fatalf("got %d finished rounds, want 1", len(tt.rounds))
+	}
+
+	// a busted double still loses when the dealer busts too
+	settled := make(map[string][]handResult)
+	for _, e := range tt.rounds[0].Events {
+		if e.Type == EventSettle {
+			settled[e.UID] = append(settled[e.UID], e.Result)
+		}
+	}
+	for uid, want := range map[string]int64{"a": 990, "b": 980} {
+		if len(settled[uid]) != 1 || settled[uid][0] != ResultBust {
+			t.Errorf("%s settled as %v, want once as a bust", uid, settled[uid])
+		}
+		if tt.money[uid] != want {
+			t.Errorf("%s has %d, want %d", uid, tt.money[uid], want)
+		}
+	}
+}
+
+func TestSitOutKeepsBetSeatInRound(t *testing.T) {
+	tt := newTestTable(stackShoe(
+		card{Spade, Ten}, card{Spade, Nine}, card{Heart, Ten}, card{Spade, Seven}, card{Heart, Nine}, card{Heart, Seven},
+	), "a", "b")
+
+	tt.command("a", playerCommand{Action: "bet", Bet: 10, Seat: 0})
+	tt.command("a", playerCommand{Action: "sitOut", Seat: 0})
+	if tt.table.Hands[0].SittingOut {
+		t.Fatal("seat with a bet in sat out")
+	}
+
+	tt.command("b", playerCommand{Action: "bet", Bet: 10, Seat: 1})
+	if tt.table.status != PlayerTurn || len(tt.table.Hands[0].Cards) != 2 {
+		t.Fatalf("table is %s with %d cards dealt to a, want playerTurn and 2", tt.table.status, len(tt.table.Hands[0].Cards))
+	}
+}
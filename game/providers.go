@@ -0,0 +1,155 @@
+package game
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+
+	"cloud.google.com/go/firestore"
+	firebase "firebase.google.com/go/v4"
+	"firebase.google.com/go/v4/auth"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// verifies the token a client sends when connecting
+type authProvider interface {
+	// returns the uid and display name the token belongs to
+	verify(ctx context.Context, token string) (string, string, error)
+	// checks the provider can be reached
+	ping(ctx context.Context) error
+}
+
+// persistent storage for player money
+type walletStore interface {
+	// returns a player's money, creating their wallet with the starting amount if they're new
+	load(ctx context.Context, uid string, starting int64) (int64, error)
+	save(ctx context.Context, uid string, money int64) error
+	// checks the store can be reached
+	ping(ctx context.Context) error
+}
+
+type firebaseAuth struct {
+	client *auth.Client
+}
+
+func newFirebaseAuth(ctx context.Context, app *firebase.App) (*firebaseAuth, error) {
+	client, err := app.Auth(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &firebaseAuth{client}, nil
+}
+
+func (a *firebaseAuth) verify(ctx context.Context, token string) (string, string, error) {
+	t, err := a.client.VerifyIDToken(ctx, token)
+	if err != nil {
+		return "", "", err
+	}
+
+	ur, err := a.client.GetUser(ctx, t.UID)
+	if err != nil {
+		return "", "", err
+	}
+
+	return t.UID, ur.DisplayName, nil
+}
+
+// looks up a user that shouldn't exist, any answer means firebase is reachable
+func (a *firebaseAuth) ping(ctx context.Context) error {
+	_, err := a.client.GetUser(ctx, "readyz")
+	if err != nil && !auth.IsUserNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// accepts any token as "uid" or "uid:display name", only meant for local testing
+type devAuth struct{}
+
+func (devAuth) verify(ctx context.Context, token string) (string, string, error) {
+	uid, name, _ := strings.Cut(token, ":")
+	if uid == "" {
+		return "", "", errors.New("empty token")
+	}
+	if name == "" {
+		name = uid
+	}
+	return uid, name, nil
+}
+
+func (devAuth) ping(ctx context.Context) error {
+	return nil
+}
+
+type firestoreStore struct {
+	client *firestore.Client
+}
+
+func (s *firestoreStore) load(ctx context.Context, uid string, starting int64) (int64, error) {
+	// fails harmlessly if the document already exists
+	s.client.Collection("users").Doc(uid).Create(ctx,
+		map[string]interface{}{
+			"money": starting,
+		})
+
+	doc, err := s.client.Collection("users").Doc(uid).Get(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	money, ok := doc.Data()["money"].(int64)
+	if !ok {
+		return 0, errors.New("user money is not an integer")
+	}
+	return money, nil
+}
+
+func (s *firestoreStore) save(ctx context.Context, uid string, money int64) error {
+	_, err := s.client.Collection("users").Doc(uid).Set(ctx,
+		map[string]interface{}{
+			"money": money,
+		})
+	return err
+}
+
+func (s *firestoreStore) ping(ctx context.Context) error {
+	_, err := s.client.Collection("users").Doc("readyz").Get(ctx)
+	if err != nil && status.Code(err) != codes.NotFound {
+		return err
+	}
+	return nil
+}
+
+// keeps wallets in memory only, everything is lost on restart
+type memoryStore struct {
+	wallets map[string]int64
+	lock    sync.Mutex
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{wallets: make(map[string]int64)}
+}
+
+func (s *memoryStore) load(ctx context.Context, uid string, starting int64) (int64, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, ok := s.wallets[uid]; !ok {
+		s.wallets[uid] = starting
+	}
+	return s.wallets[uid], nil
+}
+
+func (s *memoryStore) save(ctx context.Context, uid string, money int64) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.wallets[uid] = money
+	return nil
+}
+
+func (s *memoryStore) ping(ctx context.Context) error {
+	return nil
+}
@@ -0,0 +1,59 @@
+package game
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// adds a room created by uid unless they or the server have too many, otherwise the status and reason it was refused
+func (server *server) createRoom(uid string, config tableConfig) (*room, int, string) {
+	server.createLock.Lock()
+	defer server.createLock.Unlock()
+
+	mine, created := 0, 0
+	server.roomLock.RLock()
+	for _, room := range server.rooms {
+		if room.lobby {
+			continue
+		}
+		created++
+		if room.creator == uid {
+			mine++
+		}
+	}
+	server.roomLock.RUnlock()
+
+	switch perUser, max := server.config.RoomsPerUser, server.config.MaxRooms; {
+	case perUser > 0 && mine >= perUser:
+		return nil, http.StatusForbidden, fmt.Sprintf("you already have %d open rooms, wait for one to close once it's empty", mine)
+	case max > 0 && created >= max:
+		return nil, http.StatusTooManyRequests, "too many rooms are open, try again later"
+	}
+
+	return server.addRoom(server.generateNewRoomCode(), config, uid), http.StatusCreated, ""
+}
+
+// closes created rooms once nobody has been connected for emptyRoomTimeout, whether or not anyone ever joined;
+// lobby rooms always stay open
+func (server *server) closeEmptyRooms() {
+	timeout := time.Duration(server.config.EmptyRoomTimeout) * time.Millisecond
+	for range time.Tick(min(time.Minute, timeout)) {
+		server.closeRoomsEmptyFor(timeout)
+	}
+}
+
+func (server *server) closeRoomsEmptyFor(timeout time.Duration) {
+	now := time.Now()
+	empty := []string{}
+	for _, room := range server.allRooms() {
+		if !room.lobby && room.emptyFor(now) >= timeout {
+			empty = append(empty, room.code)
+		}
+	}
+
+	// someone may have connected since, which closeRoom checks again
+	for _, code := range empty {
+		server.closeRoom(code, "room was left empty", timeout)
+	}
+}
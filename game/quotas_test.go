@@ -0,0 +1,62 @@
+package game
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func newTestServer(config Config) *server {
+	return &server{
+		config:  config,
+		rooms:   make(map[string]*room),
+		players: make(map[string]int64),
+		stats:   make(map[string]*playerStats),
+		history: &memoryHistory{},
+		ledger:  newMemoryLedger(nil),
+		boards:  newLeaderboards(),
+	}
+}
+
+func TestRoomQuotas(t *testing.T) {
+	server := newTestServer(Config{RoomsPerUser: 1, MaxRooms: 2, Rooms: map[string]tableConfig{"lobby": defaultTableConfig()}})
+	server.addRoom("lobby", defaultTableConfig(), "")
+
+	create := func(uid string, want int) *room {
+		t.Helper()
+		room, status, reason := server.createRoom(uid, defaultTableConfig())
+		if status != want {
+			t.Fatalf("%s creating a room got %d %q, want %d", uid, status, reason, want)
+		}
+		return room
+	}
+	first := create("a", http.StatusCreated)
+	server.boards.add(first.code, "a", handOutcome{Result: ResultWin, Net: 10, Time: time.Now()})
+	create("a", http.StatusForbidden)
+	create("b", http.StatusCreated)
+	// the lobby room doesn't count toward either quota, but a and b's rooms fill the server
+	create("c", http.StatusTooManyRequests)
+
+	// a room someone was just in stays open
+	server.closeRoomsEmptyFor(time.Minute)
+	if len(server.allRooms()) != 3 {
+		t.Fatalf("%d rooms open after closing empty ones too early, want 3", len(server.allRooms()))
+	}
+
+	first.clientLock.Lock()
+	first.lastClient = time.Now().Add(-2 * time.Minute)
+	first.clientLock.Unlock()
+	server.closeRoomsEmptyFor(time.Minute)
+	if _, ok := server.room(first.code); ok {
+		t.Fatal("room left empty wasn't closed")
+	}
+	if _, ok := server.room("lobby"); !ok {
+		t.Fatal("lobby room was closed")
+	}
+	if _, ok := server.boards.rooms[first.code]; ok {
+		t.Error("closed room's leaderboard was kept")
+	}
+
+	create("c", http.StatusCreated)
+	create("a", http.StatusTooManyRequests)
+}
@@ -1,18 +1,41 @@
 package game
 
 import (
-	"log"
+	"log/slog"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
 )
 
 type room struct {
+	code           string
 	table          table
 	clients        map[*websocket.Conn]string
+	clientLock     sync.Mutex
 	wsCommands     chan wsCommand
 	playersUpdates chan playersUpdate
+	grants         chan grant
+	flags          chan countFlag
+	configs        chan tableConfig
+	inspect        chan chan tableSnapshot
 	broadcast      chan []byte
+	private        chan directMessage
+	drain          chan struct{} // finish the current round, then stop
+	abort          chan struct{} // refund open bets and stop
+	drained        chan struct{} // closed once the table has stopped
+	snapshots      snapshotStore // nil if snapshots are disabled
+	snapshotEvery  time.Duration
+	history        historyStore
+	verifyReplays  bool
+	training       bool              // plays with play money and stays out of history, stats and leaderboards
+	lobby          bool              // one of the rooms from the config, which are never closed for being empty or counted against quotas
+	creator        string            // uid of whoever created the room, empty for lobby rooms and rooms from snapshots taken before creators were kept
+	lastClient     time.Time         // when a client last connected or left, or the room started; guarded by clientLock
+	closed         bool              // the room is being closed, so it takes no new clients and leaves its metrics alone; guarded by clientLock
+	isFlagged      func(string) bool // nil if nobody is watched for counting cards
+	log            *slog.Logger
+	debug          roomDebug
 }
 
 type wsCommand struct {
@@ -20,65 +43,279 @@ type wsCommand struct {
 	playerId string
 }
 
+type directMessage struct {
+	playerId string
+	message  []byte
+}
+
 type playersUpdate struct {
 	playerId    string
 	displayName string
 	connect     bool
 }
 
+// false if the room is being closed
+func (room *room) addClient(c *websocket.Conn, uid string) bool {
+	room.clientLock.Lock()
+	defer room.clientLock.Unlock()
+
+	if room.closed {
+		return false
+	}
+	room.clients[c] = uid
+	room.lastClient = time.Now()
+	clientsGauge.WithLabelValues(room.code).Inc()
+	return true
+}
+
+func (room *room) hasClient(uid string) bool {
+	room.clientLock.Lock()
+	defer room.clientLock.Unlock()
+
+	for _, v := range room.clients {
+		if v == uid {
+			return true
+		}
+	}
+	return false
+}
+
 func (room *room) removePlayer(c *websocket.Conn) {
+	room.clientLock.Lock()
+	uid := room.clients[c]
 	allGone := true
 	for k, v := range room.clients {
-		if k != c && v == room.clients[c] {
+		if k != c && v == uid {
 			allGone = false
 			break
 		}
 	}
+	delete(room.clients, c)
+	room.lastClient = time.Now()
+	if !room.closed {
+		clientsGauge.WithLabelValues(room.code).Dec()
+	}
+	room.clientLock.Unlock()
 
 	if allGone {
-		room.playersUpdates <- playersUpdate{playerId: room.clients[c], connect: false}
+		select {
+		case room.playersUpdates <- playersUpdate{playerId: uid, connect: false}:
+		case <-room.drained:
+		}
 	}
-	delete(room.clients, c)
 }
 
 func (room *room) startTable() {
-	room.table.resetHands()
+	room.debug.running.Store(true)
+	defer room.debug.running.Store(false)
+
+	room.table.broadcast()
+	room.table.beginRound()
 
 	nullActionTimer := time.NewTimer(0)
 	defer nullActionTimer.Stop()
 
+	// bots act one event at a time, each after a short delay
+	botTimer := time.NewTimer(0)
+	defer botTimer.Stop()
+	<-botTimer.C
+	botPending := false
+
+	var snapshotTicks <-chan time.Time
+	if room.snapshots != nil {
+		snapshotTicker := time.NewTicker(room.snapshotEvery)
+		defer snapshotTicker.Stop()
+		snapshotTicks = snapshotTicker.C
+		room.debug.snapshotAt.Store(time.Now().Add(room.snapshotEvery).UnixNano())
+	}
+
 	for {
 		switch room.table.status {
 		case Betting:
-			if room.table.beginBettingTimeLimit {
-				room.table.beginBettingTimeLimit = false
-				nullActionTimer.Reset(room.table.bettingTimeLimit + time.Second)
-			} else if !room.table.someBetsIn() {
+			if room.table.someBetsIn() {
+				nullActionTimer.Reset(time.Until(room.table.actionDeadline()) + time.Second)
+				room.debug.timeoutAt.Store(room.table.actionDeadline().Add(time.Second).UnixNano())
+			} else {
 				nullActionTimer.Stop()
+				room.debug.timeoutAt.Store(0)
 			}
 		case PlayerTurn:
-			nullActionTimer.Reset(room.table.moveTimeLimit + time.Second)
+			nullActionTimer.Reset(time.Until(room.table.actionDeadline()) + time.Second)
+			room.debug.timeoutAt.Store(room.table.actionDeadline().Add(time.Second).UnixNano())
+		}
+
+		if _, ok := room.table.nextBotEvent(); ok && !botPending {
+			delay := room.table.botDelay()
+			botTimer.Reset(delay)
+			room.debug.botAt.Store(time.Now().Add(delay).UnixNano())
+			botPending = true
 		}
 
 		select {
 		case command := <-room.wsCommands:
 			room.table.handleWSCommand(command)
 		case playerUpdate := <-room.playersUpdates:
-			room.table.handlePlayerUpdate(playerUpdate)
+			if playerUpdate.connect {
+				e := roundEvent{Type: EventConnect, Time: time.Now(), UID: playerUpdate.playerId, DisplayName: playerUpdate.displayName, Money: room.table.getMoney(playerUpdate.playerId)}
+				if room.table.getStats != nil {
+					stats := room.table.getStats(playerUpdate.playerId)
+					e.Stats = &stats
+				}
+				if room.isFlagged != nil {
+					e.Flagged = room.isFlagged(playerUpdate.playerId)
+				}
+				room.table.apply(e)
+			} else {
+				if r, ok := room.table.report(playerUpdate.playerId); ok && r.Decisions > 0 {
+					room.log.Info("training session ended", "uid", playerUpdate.playerId, "decisions", r.Decisions, "accuracy", r.Accuracy, "evLost", r.EVLost)
+				}
+				room.table.apply(roundEvent{Type: EventDisconnect, Time: time.Now(), UID: playerUpdate.playerId})
+			}
+		case g := <-room.grants:
+			room.table.apply(roundEvent{Type: EventGrant, Time: time.Now(), UID: g.UID, Money: g.Amount, Grant: g.Kind, Reason: g.Reason})
+		case f := <-room.flags:
+			room.table.apply(roundEvent{Type: EventFlag, Time: time.Now(), UID: f.UID, Flagged: f.Flagged})
+		case c := <-room.configs:
+			room.table.apply(roundEvent{Type: EventConfig, Time: time.Now(), Config: &c})
+		case reply := <-room.inspect:
+			reply <- room.table.snapshot()
+		case <-botTimer.C:
+			botPending = false
+			room.debug.botAt.Store(0)
+			if e, ok := room.table.nextBotEvent(); ok {
+				e.Time = time.Now()
+				room.table.apply(e)
+			}
 		case <-nullActionTimer.C:
-			room.table.handleNullAction()
+			room.table.apply(roundEvent{Type: EventTimeout, Time: time.Now()})
+		case <-room.drain:
+			room.table.apply(roundEvent{Type: EventDrain, Time: time.Now()})
+		case <-room.abort:
+			room.table.apply(roundEvent{Type: EventAbort, Time: time.Now()})
+		case <-snapshotTicks:
+			room.debug.snapshotAt.Store(time.Now().Add(room.snapshotEvery).UnixNano())
+			room.saveSnapshot()
+		}
+
+		room.updateGauges()
+
+		// stop once draining and no round is in progress
+		if room.table.draining && room.table.status == Betting && !room.table.someBetsIn() {
+			room.saveSnapshot()
+			close(room.drained)
+			return
 		}
 	}
 }
 
 func (room *room) broadcastMessages() {
 	for {
-		message := <-room.broadcast
-		for c := range room.clients {
+		var message []byte
+		to := ""
+		select {
+		case message = <-room.broadcast:
+		case m := <-room.private:
+			message, to = m.message, m.playerId
+		case <-room.drained:
+			// nothing broadcasts once the table has stopped, so closed rooms don't keep this goroutine
+			return
+		}
+
+		start := time.Now()
+		room.debug.sendingSince.Store(start.UnixNano())
+		room.clientLock.Lock()
+		for c, uid := range room.clients {
+			if to != "" && uid != to {
+				continue
+			}
 			err := c.WriteMessage(websocket.TextMessage, message)
 			if err != nil {
-				log.Println("error sending to websocket:", err)
+				room.log.Warn("error sending to websocket", "uid", uid, "err", err)
 			}
 		}
+		room.clientLock.Unlock()
+		room.debug.sendingSince.Store(0)
+		fanOutSeconds.Observe(time.Since(start).Seconds())
+	}
+}
+
+// tells every client why they are being disconnected and closes their connections
+func (room *room) closeClients(code int, reason string) {
+	message := websocket.FormatCloseMessage(code, reason)
+
+	room.clientLock.Lock()
+	for c := range room.clients {
+		c.WriteControl(websocket.CloseMessage, message, time.Now().Add(time.Second))
+		c.Close()
+	}
+	room.clientLock.Unlock()
+}
+
+// called by the table whenever a round ends
+func (room *room) finishRound(l roundLog) {
+	log := room.log.With("round", l.ID)
+	log.Debug("round finished", "events", len(l.Events), "broadcasts", l.Broadcasts)
+
+	// training tables play for play money, none of it is kept
+	if records := handRecords(room.code, l); !room.training && len(records) > 0 {
+		err := room.history.add(records)
+		if err != nil {
+			log.Error("error saving hand history", "err", err)
+		}
+	}
+
+	if room.verifyReplays {
+		go func() {
+			err := verifyRound(l)
+			if err != nil {
+				log.Error("round does not replay", "err", err)
+			}
+		}()
+	}
+}
+
+// hands the table drain or abort, true once it has taken it or already stopped; false if giveUp comes first
+func (room *room) signal(ch chan struct{}, giveUp <-chan struct{}) bool {
+	select {
+	case ch <- struct{}{}:
+		return true
+	case <-room.drained:
+		return true
+	case <-giveUp:
+		return false
+	}
+}
+
+// asks the room's table for its current state, which only the table's goroutine may read; false if the table has stopped
+func (room *room) tableState() (tableSnapshot, bool) {
+	reply := make(chan tableSnapshot, 1)
+	select {
+	case room.inspect <- reply:
+	case <-room.drained:
+		return tableSnapshot{}, false
+	}
+	return <-reply, true
+}
+
+// stops the room taking clients so it can be closed; with emptyFor set, only if nobody has been connected for that long
+func (room *room) startClosing(emptyFor time.Duration) bool {
+	room.clientLock.Lock()
+	defer room.clientLock.Unlock()
+
+	if emptyFor > 0 && (len(room.clients) > 0 || time.Since(room.lastClient) < emptyFor) {
+		return false
+	}
+	room.closed = true
+	return true
+}
+
+// how long nobody has been connected, 0 if someone is
+func (room *room) emptyFor(now time.Time) time.Duration {
+	room.clientLock.Lock()
+	defer room.clientLock.Unlock()
+
+	if len(room.clients) > 0 {
+		return 0
 	}
+	return now.Sub(room.lastClient)
 }
@@ -0,0 +1,395 @@
+package game
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"maps"
+	"math/rand"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type eventType string
+
+// events that drive the table
+const (
+	EventCommand    eventType = "command" // a player's command, whose action is in Command
+	EventConnect    eventType = "connect"
+	EventDisconnect eventType = "disconnect"
+	EventTimeout    eventType = "timeout"
+	EventDrain      eventType = "drain"
+	EventAbort      eventType = "abort"
+	EventGrant      eventType = "grant"
+	EventFlag       eventType = "flag"
+	EventConfig     eventType = "config"
+	EventBalance    eventType = "balance" // a balance changed outside the table, by another room or a grant paid elsewhere
+)
+
+// events the table emits as a result of the ones above
+const (
+	EventShuffle eventType = "shuffle"
+	EventDeal    eventType = "deal"
+	EventAutoBet eventType = "autoBetPlaced"
+	EventSettle  eventType = "settle"
+	EventRefund  eventType = "refund"
+)
+
+type roundEvent struct {
+	Type        eventType      `json:"type"`
+	Time        time.Time      `json:"time"`
+	UID         string         `json:"uid,omitempty"`
+	DisplayName string         `json:"displayName,omitempty"` // connect
+	Money       int64          `json:"money,omitempty"`       // connect, balance: balance now, grant: amount granted
+	Grant       grantKind      `json:"grant,omitempty"`       // grant: kind of grant
+	Reason      string         `json:"reason,omitempty"`      // grant: why it was given
+	Stats       *statsSummary  `json:"stats,omitempty"`       // connect: stats when connecting
+	Flagged     bool           `json:"flagged,omitempty"`     // connect, flag: whether the player is flagged for counting cards
+	Command     *playerCommand `json:"command,omitempty"`
+	Config      *tableConfig   `json:"config,omitempty"` // config: settings changed by an admin
+	Hand        int            `json:"hand"`             // index of the hand involved, -1 for the dealer
+	Cards       []card         `json:"cards,omitempty"`  // deal: card dealt, shuffle: new shoe order
+	Bet         int64          `json:"bet,omitempty"`
+	Payout      int64          `json:"payout,omitempty"`
+	Result      handResult     `json:"result,omitempty"` // settle
+}
+
+// whether only the server can send an event of this type, players' commands can't use these as actions
+func (t eventType) internal() bool {
+	switch t {
+	case EventConnect, EventDisconnect, EventTimeout, EventDrain, EventAbort, EventGrant, EventFlag, EventConfig, EventBalance:
+		return true
+	}
+	return false
+}
+
+// whether the event is one the table reacts to, rather than one it emitted
+func (e roundEvent) isInput() bool {
+	return e.Command != nil || e.Type.internal()
+}
+
+// everything that happened in one round, enough to replay it exactly
+type roundLog struct {
+	ID            string                  `json:"id"`
+	Started       time.Time               `json:"started"`
+	Start         json.RawMessage         `json:"start"` // table snapshot when the round began
+	Draining      bool                    `json:"draining"`
+	Money         map[string]int64        `json:"money"` // balances when the round began
+	Stats         map[string]statsSummary `json:"stats"` // stats when the round began, nil if the table does not broadcast stats
+	Events        []roundEvent            `json:"events"`
+	Broadcasts    int                     `json:"broadcasts"`
+	BroadcastHash string                  `json:"broadcastHash"` // sha256 over every broadcast in order
+	Ended         time.Time               `json:"ended"`
+	Dealer        []card                  `json:"dealer"` // dealer's cards when the round ended
+	Hands         []Hand                  `json:"hands"`  // every hand when the round ended
+	hash          hash.Hash
+}
+
+// applies an input event to the table; everything the table does while handling it happens at the event's time
+func (t *table) apply(e roundEvent) {
+	// drop the monotonic clock reading so live and replayed tables do the same time math
+	e.Time = e.Time.Round(0)
+	t.now = e.Time
+	if e.Type != EventBalance {
+		t.recordBalanceChanges()
+	}
+	defer t.rememberBalances()
+	e.Hand = t.ActiveHand
+	t.record(e)
+
+	// commands come from players, so they never get to act as one of the server's events
+	if e.Command != nil {
+		t.handleCommand(e.UID, *e.Command)
+		return
+	}
+
+	switch e.Type {
+	case EventConnect:
+		t.handlePlayerUpdate(playersUpdate{e.UID, e.DisplayName, true})
+		t.playerWithUID(e.UID).flagged = e.Flagged
+	case EventDisconnect:
+		t.handlePlayerUpdate(playersUpdate{playerId: e.UID, connect: false})
+	case EventTimeout:
+		t.handleNullAction()
+	case EventDrain:
+		t.startDraining()
+	case EventAbort:
+		t.refundBets()
+	case EventGrant:
+		why := grantReason(e.Grant, e.UID, e.Reason)
+		why.Round = t.roundID()
+		// checked again here the same way the wallet does, so a replay takes away what the live table did
+		t.deltaMoney(e.UID, max(e.Money, -t.getMoney(e.UID)), why)
+		t.broadcast()
+	case EventFlag:
+		if p := t.playerWithUID(e.UID); p != nil {
+			p.flagged = e.Flagged
+		}
+	case EventConfig:
+		if e.Config != nil {
+			t.applyConfig(*e.Config)
+			t.broadcast()
+		}
+	}
+}
+
+// logs balances that changed since the last event so a replay sees the same money the live table did
+func (t *table) recordBalanceChanges() {
+	if t.round == nil {
+		return
+	}
+
+	before := t.balances
+	uids := make([]string, 0, len(before))
+	for uid := range before {
+		uids = append(uids, uid)
+	}
+	slices.Sort(uids)
+	for _, uid := range uids {
+		if money := t.getMoney(uid); money != before[uid] {
+			t.apply(roundEvent{Type: EventBalance, Time: t.now, UID: uid, Money: money})
+		}
+	}
+}
+
+func (t *table) rememberBalances() {
+	if t.round == nil {
+		t.balances = nil
+		return
+	}
+
+	balances := make(map[string]int64)
+	for _, p := range t.Players {
+		balances[p.UID] = t.getMoney(p.UID)
+	}
+	for _, h := range t.Hands {
+		if h.PlayerUID != "" {
+			balances[h.PlayerUID] = t.getMoney(h.PlayerUID)
+		}
+	}
+	t.balances = balances
+}
+
+func (t *table) record(e roundEvent) {
+	if t.round == nil {
+		return
+	}
+
+	e.Time = t.now
+	t.round.Events = append(t.round.Events, e)
+}
+
+func (t *table) recordBroadcast(out []byte) {
+	if t.round == nil {
+		return
+	}
+
+	t.round.hash.Write(out)
+	t.round.Broadcasts++
+}
+
+// starts logging a new round from the table's current state and places auto bets
+func (t *table) beginRound() {
+	if t.headless {
+		if !t.draining {
+			t.placeAutoBets()
+		}
+		return
+	}
+
+	start, _ := json.Marshal(t.snapshot())
+
+	money := make(map[string]int64)
+	for _, p := range t.Players {
+		money[p.UID] = t.getMoney(p.UID)
+	}
+	for _, h := range t.Hands {
+		if h.PlayerUID != "" {
+			money[h.PlayerUID] = t.getMoney(h.PlayerUID)
+		}
+	}
+
+	var stats map[string]statsSummary
+	if t.getStats != nil {
+		stats = make(map[string]statsSummary)
+		for uid := range money {
+			stats[uid] = t.getStats(uid)
+		}
+	}
+
+	t.round = &roundLog{
+		ID:       uuid.NewString(),
+		Started:  t.now,
+		Start:    start,
+		Draining: t.draining,
+		Money:    money,
+		Stats:    stats,
+		hash:     sha256.New(),
+	}
+
+	if !t.draining {
+		t.placeAutoBets()
+	}
+}
+
+// keeps the final cards of the round before the table is cleared
+func (t *table) recordOutcome() {
+	if t.round == nil {
+		return
+	}
+
+	t.round.Ended = t.now
+	t.round.Dealer = slices.Clone(t.dealer.Cards)
+	t.round.Hands = slices.Clone(t.Hands)
+	for i := range t.round.Hands {
+		t.round.Hands[i].Cards = slices.Clone(t.round.Hands[i].Cards)
+	}
+}
+
+// ID of the round in progress, empty if there isn't one or the table doesn't log rounds
+func (t *table) roundID() string {
+	if t.round == nil {
+		return ""
+	}
+	return t.round.ID
+}
+
+func (t *table) endRound() {
+	if t.round == nil {
+		return
+	}
+
+	t.round.BroadcastHash = hex.EncodeToString(t.round.hash.Sum(nil))
+	if t.roundDone != nil {
+		t.roundDone(*t.round)
+	}
+	t.round = nil
+}
+
+// reshuffles the shoe, replayed tables reuse the order the live table got
+func (t *table) reshuffle() {
+	if t.replayShuffles != nil {
+		if len(t.replayShuffles) > 0 {
+			copy(t.deck.cards, t.replayShuffles[0])
+			t.replayShuffles = t.replayShuffles[1:]
+		}
+	} else {
+		rand.Shuffle(len(t.deck.cards), func(i, j int) {
+			t.deck.cards[i], t.deck.cards[j] = t.deck.cards[j], t.deck.cards[i]
+		})
+	}
+
+	t.deck.index = 0
+	t.record(roundEvent{Type: EventShuffle, Hand: -1, Cards: slices.Clone(t.deck.cards)})
+}
+
+// deals a card from the shoe to a hand, reshuffling first if the shoe is used up
+func (t *table) dealTo(h *Hand) {
+	if t.deck.index >= len(t.deck.cards) {
+		t.reshuffle()
+	}
+
+	t.deck.dealTo(h)
+	t.record(roundEvent{Type: EventDeal, UID: h.PlayerUID, Hand: t.handIndex(h), Cards: slices.Clone(h.Cards[len(h.Cards)-1:])})
+}
+
+// returns the index of a hand in t.Hands, -1 for the dealer
+func (t *table) handIndex(h *Hand) int {
+	for i := range t.Hands {
+		if &t.Hands[i] == h {
+			return i
+		}
+	}
+	return -1
+}
+
+// rebuilds a round from its starting state and input events, returning the log the replayed table produced
+func replayRound(l roundLog) (roundLog, error) {
+	var s tableSnapshot
+	err := json.Unmarshal(l.Start, &s)
+	if err != nil {
+		return roundLog{}, err
+	}
+
+	money := maps.Clone(l.Money)
+	t := restoreTable(s, nil, func(uid string) int64 { return money[uid] }, func(uid string, delta int64, _ moneyReason) { money[uid] += delta })
+	t.actionTimeStart = s.ActionTimeStart
+	t.draining = l.Draining
+	t.now = l.Started
+
+	stats := maps.Clone(l.Stats)
+	if stats != nil {
+		t.getStats = func(uid string) statsSummary { return stats[uid] }
+		t.handSettled = func(uid string, o handOutcome) {
+			s := stats[uid]
+			s.add(o)
+			stats[uid] = s
+		}
+	}
+
+	t.replayShuffles = [][]card{}
+	for _, e := range l.Events {
+		if e.Type == EventShuffle {
+			t.replayShuffles = append(t.replayShuffles, e.Cards)
+		}
+	}
+
+	var replayed *roundLog
+	t.roundDone = func(r roundLog) {
+		if replayed == nil {
+			replayed = &r
+		}
+	}
+
+	t.round = &roundLog{ID: l.ID, Started: l.Started, Start: l.Start, Draining: l.Draining, Money: l.Money, Stats: l.Stats, hash: sha256.New()}
+	if !t.draining {
+		t.placeAutoBets()
+	}
+
+	for _, e := range l.Events {
+		if !e.isInput() {
+			continue
+		}
+		if e.Type == EventConnect || e.Type == EventBalance {
+			money[e.UID] = e.Money
+		}
+		if e.Type == EventConnect {
+			if e.Stats != nil && stats != nil {
+				stats[e.UID] = *e.Stats
+			}
+		}
+		t.apply(e)
+	}
+
+	// rounds still in progress end wherever their events stop
+	if replayed == nil {
+		t.endRound()
+	}
+	return *replayed, nil
+}
+
+// replays a round and checks that it produced the same events and byte-identical broadcasts
+func verifyRound(l roundLog) error {
+	replayed, err := replayRound(l)
+	if err != nil {
+		return err
+	}
+
+	if replayed.Broadcasts != l.Broadcasts || replayed.BroadcastHash != l.BroadcastHash {
+		return fmt.Errorf("replay sent %d broadcasts with hash %s, live round sent %d with hash %s",
+			replayed.Broadcasts, replayed.BroadcastHash, l.Broadcasts, l.BroadcastHash)
+	}
+
+	live, _ := json.Marshal(l.Events)
+	replay, _ := json.Marshal(replayed.Events)
+	if !bytes.Equal(live, replay) {
+		return errors.New("replayed events differ from live round")
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package game
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+// a table driven by events the way a room drives it, with players seated in the order given
+type testTable struct {
+	table  *table
+	money  map[string]int64
+	rounds []roundLog
+	now    time.Time
+}
+
+// a full one-deck shoe that deals cards first and the rest of the deck after
+func stackShoe(cards ...card) []card {
+	shoe := slices.Clone(cards)
+	for _, c := range makeDeck(1).cards {
+		if !slices.Contains(cards, c) {
+			shoe = append(shoe, c)
+		}
+	}
+	return shoe
+}
+
+func newTestTable(shoe []card, uids ...string) *testTable {
+	config := defaultTableConfig()
+	config.Seats = len(uids)
+
+	tt := &testTable{money: make(map[string]int64), now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	t := newTable(nil, config, func(uid string) int64 {
+		return tt.money[uid]
+	}, func(uid string, delta int64, _ moneyReason) {
+		tt.money[uid] += delta
+	})
+	t.deck.cards, t.deck.index = shoe, 0
+	t.now, t.actionTimeStart = tt.now, tt.now
+	t.roundDone = func(l roundLog) {
+		tt.rounds = append(tt.rounds, l)
+	}
+	tt.table = &t
+	tt.table.beginRound()
+
+	for seat, uid := range uids {
+		tt.money[uid] = 1000
+		tt.apply(roundEvent{Type: EventConnect, UID: uid, DisplayName: uid, Money: 1000})
+		tt.command(uid, playerCommand{Action: "join", Seat: seat})
+	}
+	return tt
+}
+
+// applies an event a second after the one before
+func (tt *testTable) apply(e roundEvent) {
+	tt.now = tt.now.Add(time.Second)
+	e.Time = tt.now
+	tt.table.apply(e)
+}
+
+func (tt *testTable) command(uid string, cmd playerCommand) {
+	tt.apply(roundEvent{Type: EventCommand, UID: uid, Command: &cmd})
+}
+
+func TestReplayMatchesLiveRound(t *testing.T) {
+	// a: 5 6, b: 8 8, c: 10 7, dealer: 10 6; then a's double, b's hit on each split hand and the dealer's bust
+	tt := newTestTable(stackShoe(
+		card{Spade, Five}, card{Spade, Eight}, card{Spade, Ten}, card{Heart, Ten},
+		card{Spade, Six}, card{Heart, Eight}, card{Spade, Seven}, card{Heart, Six},
+		card{Spade, Nine}, card{Spade, Three}, card{Spade, King}, card{Diamond, Ten},
+	), "a", "b", "c")
+
+	for seat, uid := range []string{"a", "b", "c"} {
+		tt.command(uid, playerCommand{Action: "bet", Bet: 10, Seat: seat})
+	}
+	tt.command("a", playerCommand{Action: "double"})
+	tt.command("b", playerCommand{Action: "split"})
+	tt.command("b", playerCommand{Action: "hit"})
+	tt.command("b", playerCommand{Action: "stand"})
+	tt.command("b", playerCommand{Action: "hit"})
+	tt.command("b", playerCommand{Action: "stand"})
+	tt.apply(roundEvent{Type: EventTimeout})
+
+	if len(tt.rounds) != 1 {
+		t.Fatalf("got %d finished rounds, want 1", len(tt.rounds))
+	}
+	l := tt.rounds[0]
+
+	settled := 0
+	for _, e := range l.Events {
+		if e.Type == EventSettle {
+			settled++
+		}
+	}
+	if settled != 4 {
+		t.Errorf("got %d settled hands, want 4", settled)
+	}
+	for uid, want := range map[string]int64{"a": 1020, "b": 1020, "c": 1010} {
+		if tt.money[uid] != want {
+			t.Errorf("%s has %d, want %d", uid, tt.money[uid], want)
+		}
+	}
+
+	if err := verifyRound(l); err != nil {
+		t.Fatal(err)
+	}
+
+	replayed, err := replayRound(l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.Broadcasts == 0 || replayed.Broadcasts != l.Broadcasts || replayed.BroadcastHash != l.BroadcastHash {
+		t.Errorf("replay sent %d broadcasts with hash %s, live round sent %d with hash %s",
+			replayed.Broadcasts, replayed.BroadcastHash, l.Broadcasts, l.BroadcastHash)
+	}
+}
+
+func TestCommandsCannotActAsServerEvents(t *testing.T) {
+	tt := newTestTable(stackShoe(
+		card{Spade, Ten}, card{Heart, Ten}, card{Spade, Nine}, card{Heart, Seven},
+	), "a")
+	tt.command("a", playerCommand{Action: "bet", Bet: 10, Seat: 0})
+	if tt.table.status != PlayerTurn {
+		t.Fatalf("table is %s, want playerTurn", tt.table.status)
+	}
+
+	for _, action := range []string{"abort", "drain", "timeout", "grant", "flag", "config", "balance"} {
+		tt.table.handleWSCommand(wsCommand{[]byte(`{"Action":"` + action + `"}`), "a"})
+		tt.command("a", playerCommand{Action: action})
+	}
+
+	if tt.table.status != PlayerTurn || tt.table.draining || tt.money["a"] != 990 {
+		t.Errorf("table is %s, draining %v and a has %d after reserved actions, want playerTurn, false and 990",
+			tt.table.status, tt.table.draining, tt.money["a"])
+	}
+}
+
+func TestReplaySeesMoneyWonAtAnotherTable(t *testing.T) {
+	// at the first table a stands on 17 against 19, at the second on 19 against 17
+	first := newTestTable(stackShoe(card{Spade, Ten}, card{Heart, Ten}, card{Spade, Seven}, card{Heart, Nine}), "a")
+	second := newTestTable(stackShoe(card{Spade, Ten}, card{Heart, Ten}, card{Spade, Nine}, card{Heart, Seven}), "a")
+	second.money = first.money
+
+	first.command("a", playerCommand{Action: "bet", Bet: 10, Seat: 0})
+	second.command("a", playerCommand{Action: "bet", Bet: 10, Seat: 0})
+	second.command("a", playerCommand{Action: "stand"})
+	first.command("a", playerCommand{Action: "stand"})
+
+	if len(first.rounds) != 1 || len(second.rounds) != 1 || first.money["a"] != 1000 {
+		t.Fatalf("a has %d after %d and %d rounds, want 1000 after 1 each", first.money["a"], len(first.rounds), len(second.rounds))
+	}
+
+	l := first.rounds[0]
+	changes := 0
+	for _, e := range l.Events {
+		if e.Type == EventBalance && e.UID == "a" && e.Money == 1000 {
+			changes++
+		}
+	}
+	if changes != 1 {
+		t.Errorf("first table logged %d balance changes, want the payout from the second", changes)
+	}
+	if err := verifyRound(l); err != nil {
+		t.Fatal(err)
+	}
+}
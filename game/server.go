@@ -3,126 +3,531 @@ package game
 import (
 	"context"
 	"encoding/json"
-	"log"
+	"log/slog"
+	"maps"
 	"math/rand"
 	"net/http"
+	"net/http/pprof"
 	"os"
+	"os/signal"
+	"slices"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
-	"cloud.google.com/go/firestore"
 	firebase "firebase.google.com/go/v4"
 	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		return r.Header.Get("Origin") == os.Getenv("FRONTEND")
-	},
-}
-
 type server struct {
-	rooms      map[string]room
-	players    map[string]int64
-	playerLock sync.RWMutex
-	firebase   *firebase.App
-	firestore  *firestore.Client
-	ctx        context.Context // TODO: still no idea what context actually is but keeping it here seems fine (?)
+	config        Config
+	rooms         map[string]*room
+	roomLock      sync.RWMutex
+	createLock    sync.Mutex // held while a room is checked against quotas and added
+	players       map[string]int64
+	playerLock    sync.RWMutex
+	pendingWrites sync.WaitGroup
+	draining      atomic.Bool
+	connIDs       atomic.Uint64
+	upgrader      websocket.Upgrader
+	auth          authProvider
+	store         walletStore
+	snapshots     snapshotStore // nil if snapshots are disabled
+	history       historyStore
+	stats         map[string]*playerStats
+	statsLock     sync.Mutex
+	boards        *leaderboards
+	grants        grantStore
+	grantLock     sync.Mutex
+	counts        *countWatch // nil if count watching is disabled
+	audit         adminAudit
+	ledger        *ledger
+	limits        *limits
+	bans          map[string]ban
+	banLock       sync.RWMutex
+	ctx           context.Context // TODO: still no idea what context actually is but keeping it here seems fine (?)
 }
 
 type roomInfo struct {
 	Code       string `json:"code"`
 	Seats      int    `json:"seats"`
-	TakenSeats int    `json:"takenSeats"`
+	TakenSeats int    `json:"takenSeats"` // by players
+	BotSeats   int    `json:"botSeats"`
 }
 
 type infoResponse struct {
 	Rooms []roomInfo `json:"rooms"`
 }
 
-type createRoomRequest struct {
-	Seats int
+type roomResponse struct {
+	Code       string      `json:"code"`
+	Config     tableConfig `json:"config"`
+	TakenSeats int         `json:"takenSeats"` // by players
+	BotSeats   int         `json:"botSeats"`
+	Creator    string      `json:"creator,omitempty"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
 }
 
-func StartServer() {
+func StartServer(config Config) {
 	ctx := context.Background()
-	app, err := firebase.NewApp(ctx, nil)
+	slog.SetDefault(newLogger(os.Stderr, config.Log))
+
+	server := server{
+		config:  config,
+		rooms:   make(map[string]*room),
+		players: make(map[string]int64),
+		bans:    make(map[string]ban),
+		stats:   make(map[string]*playerStats),
+		boards:  newLeaderboards(),
+		limits:  newLimits(config.Limits),
+		ctx:     ctx,
+	}
+	server.upgrader.CheckOrigin = func(r *http.Request) bool {
+		return server.allowedOrigin(r.Header.Get("Origin"))
+	}
+
+	var app *firebase.App
+	if config.Auth == "firebase" || config.Store == "firestore" {
+		var err error
+		app, err = firebase.NewApp(ctx, nil)
+		if err != nil {
+			fatal("error initializing app", "err", err)
+		}
+	}
+
+	switch config.Auth {
+	case "firebase":
+		auth, err := newFirebaseAuth(ctx, app)
+		if err != nil {
+			fatal("error initializing auth", "err", err)
+		}
+		server.auth = auth
+	case "dev":
+		slog.Warn("dev auth accepts any token, do not use in production")
+		server.auth = devAuth{}
+	}
+
+	switch config.Store {
+	case "firestore":
+		firestore, err := app.Firestore(ctx)
+		if err != nil {
+			fatal("error initializing firestore", "err", err)
+		}
+		defer firestore.Close()
+		server.store = &firestoreStore{firestore}
+	case "memory":
+		server.store = newMemoryStore()
+	}
+
+	if config.HistoryFile != "" {
+		history, err := newFileHistory(config.HistoryFile)
+		if err != nil {
+			fatal("error opening hand history", "err", err)
+		}
+		server.history = history
+	} else {
+		server.history = &memoryHistory{}
+	}
+	if config.GrantsFile != "" {
+		grants, err := newFileGrants(config.GrantsFile)
+		if err != nil {
+			fatal("error opening grants", "err", err)
+		}
+		server.grants = grants
+	} else {
+		server.grants = newMemoryGrants()
+	}
+
+	if config.AdminAuditFile != "" {
+		audit, err := newFileAudit(config.AdminAuditFile)
+		if err != nil {
+			fatal("error opening admin audit log", "err", err)
+		}
+		server.audit = audit
+	} else {
+		server.audit = &memoryAudit{}
+	}
+	if config.LedgerFile != "" {
+		ledger, err := newFileLedger(config.LedgerFile, []byte(config.LedgerKey))
+		if err != nil {
+			fatal("error opening ledger", "err", err)
+		}
+		server.ledger = ledger
+	} else {
+		server.ledger = newMemoryLedger([]byte(config.LedgerKey))
+	}
+
+	err := server.loadBans()
 	if err != nil {
-		log.Fatalf("error initializing app: %v\n", err)
+		fatal("error loading bans", "err", err)
+	}
+
+	if config.CountWatch.Window > 0 {
+		server.counts = newCountWatch(config.CountWatch)
 	}
 
-	firestore, err := app.Firestore(ctx)
+	err = server.loadStats()
 	if err != nil {
-		log.Fatalln(err)
+		fatal("error loading stats", "err", err)
 	}
-	defer firestore.Close()
 
-	server := server{
-		rooms:     make(map[string]room),
-		players:   make(map[string]int64),
-		firebase:  app,
-		firestore: firestore,
-		ctx:       ctx,
+	if config.SnapshotDir != "" {
+		snapshots, err := newFileSnapshotStore(config.SnapshotDir)
+		if err != nil {
+			fatal("error opening snapshot store", "err", err)
+		}
+		server.snapshots = snapshots
+		server.restoreRooms()
 	}
 
-	server.addRoom("roomy", 6)
-	server.addRoom("another", 4)
+	for code, room := range config.Rooms {
+		if _, ok := server.room(code); !ok {
+			server.addRoom(code, room, "")
+		}
+	}
+	go server.closeEmptyRooms()
 
 	mux := http.NewServeMux()
 
 	mux.HandleFunc("/room/{room}/ws", server.handleWebsocketConnections)
 	mux.HandleFunc("/room/{room}", server.handleRoomRequest)
+	mux.HandleFunc("/room/{room}/history", server.handleRoomHistoryRequest)
+	mux.HandleFunc("/players/{uid}/hands", server.handlePlayerHandsRequest)
+	mux.HandleFunc("/players/{uid}/stats", server.handlePlayerStatsRequest)
+	mux.HandleFunc("/leaderboard", server.handleLeaderboardRequest)
+	mux.HandleFunc("/rebuy", server.handleRebuyRequest)
+	mux.HandleFunc("/admin/grant", server.admin(server.handleAdminGrantRequest))
+	mux.HandleFunc("/admin/counting", server.admin(server.handleCountFeedRequest))
+	mux.HandleFunc("/admin/rooms", server.admin(server.handleAdminRoomsRequest))
+	mux.HandleFunc("/admin/rooms/{room}/close", server.admin(server.handleAdminCloseRequest))
+	mux.HandleFunc("/admin/rooms/{room}/config", server.admin(server.handleAdminConfigRequest))
+	mux.HandleFunc("/admin/players/{uid}/kick", server.admin(server.handleAdminKickRequest))
+	mux.HandleFunc("/admin/players/{uid}/ban", server.admin(server.handleAdminBanRequest))
+	mux.HandleFunc("/admin/announce", server.admin(server.handleAdminAnnounceRequest))
+	mux.HandleFunc("/admin/audit", server.admin(server.handleAdminAuditRequest))
+	mux.HandleFunc("/ev", server.handleEVRequest)
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/healthz", server.handleHealthRequest)
+	mux.HandleFunc("/readyz", server.handleReadyRequest)
+	mux.HandleFunc("/debug/pprof/", server.admin(pprof.Index))
+	mux.HandleFunc("/debug/pprof/cmdline", server.admin(pprof.Cmdline))
+	mux.HandleFunc("/debug/pprof/profile", server.admin(pprof.Profile))
+	mux.HandleFunc("/debug/pprof/symbol", server.admin(pprof.Symbol))
+	mux.HandleFunc("/debug/pprof/trace", server.admin(pprof.Trace))
+	mux.HandleFunc("/debug/rooms", server.admin(server.handleDebugRoomsRequest))
+	mux.HandleFunc("/debug/rooms/{room}", server.admin(server.handleDebugRoomsRequest))
 	mux.HandleFunc("/create", server.handleCreateRequest)
 	mux.HandleFunc("/info", server.handleInfoRequest)
-	http.ListenAndServe(":8080", checkCORS(mux))
+
+	s := &http.Server{
+		Addr:              config.Addr,
+		Handler:           server.checkCORS(mux),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		err := s.ListenAndServe()
+		if err != http.ErrServerClosed {
+			fatal("error serving", "addr", config.Addr, "err", err)
+		}
+	}()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGTERM, os.Interrupt)
+	<-stop
+	server.shutdown(s)
+}
+
+// stops taking new connections and rounds, lets every table finish its round (refunding bets
+// at tables that don't finish in time), then flushes wallet writes and disconnects clients
+func (server *server) shutdown(s *http.Server) {
+	slog.Info("shutting down")
+	server.draining.Store(true)
+
+	deadline := time.Now().Add(time.Duration(server.config.ShutdownTimeout) * time.Millisecond)
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+
+	s.Shutdown(ctx)
+
+	rooms := server.allRooms()
+	for _, room := range rooms {
+		room.signal(room.drain, ctx.Done())
+	}
+
+	for _, room := range rooms {
+		select {
+		case <-room.drained:
+			continue
+		case <-ctx.Done():
+		}
+
+		room.log.Warn("round did not finish in time, refunding bets")
+		// a table stuck on a broadcast takes neither signal, so give it a moment and then leave it
+		stuck, cancel := context.WithTimeout(context.Background(), time.Second)
+		if room.signal(room.drain, stuck.Done()) && room.signal(room.abort, stuck.Done()) {
+			select {
+			case <-room.drained:
+			case <-stuck.Done():
+			}
+		}
+		cancel()
+	}
+
+	server.pendingWrites.Wait()
+	// entries still failing to write are already in the log
+	flushed, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	if !server.ledger.flush(flushed.Done()) {
+		slog.Error("ledger entries were not written before shutdown")
+	}
+	cancel()
+
+	for _, room := range rooms {
+		select {
+		case <-room.drained:
+			room.closeClients(websocket.CloseGoingAway, "server shutting down")
+		default:
+			// its broadcaster may be holding clientLock on a write that never finishes
+			room.log.Error("table is stuck, shutting down without it")
+		}
+	}
+	slog.Info("shutdown complete")
 }
 
-func checkCORS(next http.Handler) http.Handler {
+// requests without an origin are same-origin and always allowed
+func (server *server) allowedOrigin(origin string) bool {
+	return origin == "" || slices.Contains(server.config.AllowedOrigins, origin)
+}
+
+func (server *server) checkCORS(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		origin := r.Header.Get("Origin")
-		if origin == os.Getenv("FRONTEND") {
+		if origin != "" && server.allowedOrigin(origin) {
 			w.Header().Set("Access-Control-Allow-Origin", origin)
 			w.Header().Add("Vary", "Origin")
+
+			// answer preflights for requests carrying a token or JSON body
+			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+				w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE")
+				w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
 		}
 		next.ServeHTTP(w, r)
 	})
 }
 
-func (server *server) addRoom(roomCode string, seats int) {
-	broadcastChannel := make(chan []byte)
+func (server *server) addRoom(roomCode string, config tableConfig, creator string) *room {
+	getMoney, deltaMoney := server.moneyFor(roomCode, config, nil)
+	return server.startRoom(roomCode, newTable(make(chan []byte), config, getMoney, deltaMoney), creator)
+}
+
+// training tables get their own play money instead of the real wallets, starting from playMoney when restoring one;
+// bots always play with play money
+func (server *server) moneyFor(roomCode string, config tableConfig, playMoney map[string]int64) (func(string) int64, func(string, int64, moneyReason)) {
+	getMoney, deltaMoney := server.getMoney, server.deltaMoney
+	if config.Training {
+		m := newPlayMoney(server.config.StartingMoney)
+		maps.Copy(m.money, playMoney)
+		getMoney = m.get
+		deltaMoney = func(uid string, delta int64, _ moneyReason) {
+			m.delta(uid, delta)
+		}
+	}
+
+	bots := newPlayMoney(100 * config.MinBet)
+	return func(uid string) int64 {
+			if isBot(uid) {
+				return bots.get(uid)
+			}
+			return getMoney(uid)
+		}, func(uid string, delta int64, why moneyReason) {
+			if isBot(uid) {
+				bots.delta(uid, delta)
+			} else {
+				why.Room = roomCode
+				deltaMoney(uid, delta, why)
+			}
+		}
+}
 
-	r := room{
-		newTable(broadcastChannel, seats, server.getMoney, server.deltaMoney),
-		make(map[*websocket.Conn]string),
-		make(chan wsCommand),
-		make(chan playersUpdate),
-		broadcastChannel,
+// brings back every room that has a valid snapshot
+func (server *server) restoreRooms() {
+	snapshots, err := server.snapshots.loadAll()
+	if err != nil {
+		fatal("error loading snapshots", "err", err)
 	}
 
+	for code, data := range snapshots {
+		var s tableSnapshot
+		err := json.Unmarshal(data, &s)
+		if err == nil {
+			err = s.validate()
+		}
+		if err != nil {
+			slog.Warn("skipping snapshot", "room", code, "err", err)
+			continue
+		}
+
+		// nobody is connected yet; load wallets of everyone at the table so payouts land on their real balance
+		uids := make(map[string]bool)
+		for i, p := range s.Players {
+			s.Players[i].Active = false
+			uids[p.UID] = true
+		}
+		for _, h := range s.Hands {
+			if h.PlayerUID != "" {
+				uids[h.PlayerUID] = true
+			}
+		}
+		for uid := range uids {
+			if isBot(uid) {
+				continue
+			}
+			money, err := server.store.load(server.ctx, uid, server.config.StartingMoney)
+			if err != nil {
+				fatal("error loading money", "room", code, "uid", uid, "err", err)
+			}
+			server.setMoney(uid, money)
+		}
+
+		// lobby rooms follow the config, so a snapshot only comes back if the table it describes still fits
+		if config, ok := server.config.Rooms[code]; ok {
+			if config.Seats != s.Config.Seats || config.Decks != s.Config.Decks || config.Training != s.Config.Training {
+				server.dropSnapshot(code, s, "room config changed")
+				continue
+			}
+			s.Config = config
+		} else if s.Lobby {
+			server.dropSnapshot(code, s, "room is no longer configured")
+			continue
+		}
+
+		getMoney, deltaMoney := server.moneyFor(code, s.Config, s.PlayMoney)
+		server.startRoom(code, restoreTable(s, make(chan []byte), getMoney, deltaMoney), s.Creator)
+		slog.Info("restored room", "room", code)
+	}
+}
+
+// removes a snapshot that won't be restored, giving back bets still on the table
+func (server *server) dropSnapshot(code string, s tableSnapshot, reason string) {
+	slog.Warn("discarding snapshot", "room", code, "reason", reason)
+	if !s.Config.Training {
+		for _, h := range s.Hands {
+			if h.Bet > 0 && !isBot(h.PlayerUID) {
+				server.deltaMoney(h.PlayerUID, h.Bet, moneyReason{Kind: "refund", Actor: "system", Room: code, Reason: reason})
+			}
+		}
+	}
+	if err := server.snapshots.remove(code); err != nil {
+		slog.Error("error removing snapshot", "room", code, "err", err)
+	}
+}
+
+func (server *server) startRoom(roomCode string, t table, creator string) *room {
+	_, lobby := server.config.Rooms[roomCode]
+	r := &room{
+		code:           roomCode,
+		table:          t,
+		clients:        make(map[*websocket.Conn]string),
+		wsCommands:     make(chan wsCommand),
+		playersUpdates: make(chan playersUpdate),
+		grants:         make(chan grant),
+		flags:          make(chan countFlag),
+		configs:        make(chan tableConfig),
+		inspect:        make(chan chan tableSnapshot),
+		broadcast:      t.Broadcast,
+		private:        make(chan directMessage),
+		drain:          make(chan struct{}),
+		abort:          make(chan struct{}),
+		drained:        make(chan struct{}),
+		snapshots:      server.snapshots,
+		history:        server.history,
+		snapshotEvery:  time.Duration(server.config.SnapshotInterval) * time.Millisecond,
+		verifyReplays:  server.config.VerifyReplays,
+		training:       t.training,
+		lobby:          lobby,
+		creator:        creator,
+		log:            slog.With("room", roomCode),
+		lastClient:     time.Now(),
+	}
+	r.table.roundDone = r.finishRound
+	r.table.metrics = newTableMetrics(roomCode)
+	r.table.broadcastsWaiting = &r.debug.broadcastsWaiting
+	r.table.sendPrivate = func(uid string, message []byte) {
+		r.debug.broadcastsWaiting.Add(1)
+		r.private <- directMessage{uid, message}
+		r.debug.broadcastsWaiting.Add(-1)
+	}
+	if !r.training {
+		r.table.getStats = server.statsSummary
+		r.table.handSettled = func(uid string, o handOutcome) {
+			server.handSettled(roomCode, uid, o)
+		}
+		if server.counts != nil {
+			r.isFlagged = server.counts.isFlagged
+			r.table.betPlaced = func(uid string, s countSample) {
+				server.betPlaced(roomCode, uid, s)
+			}
+		}
+	}
+
+	server.roomLock.Lock()
 	server.rooms[roomCode] = r
+	server.roomLock.Unlock()
+
+	roomsGauge.Inc()
 	go r.startTable()
 	go r.broadcastMessages()
+	return r
 }
 
-func (server *server) handleInfoRequest(w http.ResponseWriter, r *http.Request) {
-	info := infoResponse{make([]roomInfo, len(server.rooms))}
+func (server *server) room(roomCode string) (*room, bool) {
+	server.roomLock.RLock()
+	defer server.roomLock.RUnlock()
+
+	r, ok := server.rooms[roomCode]
+	return r, ok
+}
 
-	i := 0
-	for k, v := range server.rooms {
-		info.Rooms[i].Code = k
-		info.Rooms[i].Seats = v.table.seats
-		info.Rooms[i].TakenSeats = v.table.seatsTaken()
-		i++
+// every room open right now, for sending to without holding roomLock
+func (server *server) allRooms() []*room {
+	server.roomLock.RLock()
+	defer server.roomLock.RUnlock()
+
+	rooms := make([]*room, 0, len(server.rooms))
+	for _, room := range server.rooms {
+		rooms = append(rooms, room)
 	}
+	return rooms
+}
 
-	out, err := json.Marshal(info)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+func (server *server) handleInfoRequest(w http.ResponseWriter, r *http.Request) {
+	info := infoResponse{[]roomInfo{}}
+
+	// rooms that have closed or whose table is stuck are left out rather than holding up the list
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second)
+	defer cancel()
+	for _, room := range server.allRooms() {
+		reply := make(chan tableSnapshot, 1)
+		select {
+		case room.inspect <- reply:
+			t := <-reply
+			players, bots := t.seatsTaken()
+			info.Rooms = append(info.Rooms, roomInfo{room.code, t.Config.Seats, players, bots})
+		case <-room.drained:
+		case <-ctx.Done():
+		}
 	}
 
-	w.WriteHeader(http.StatusOK)
-	w.Write(out)
+	writeJSON(w, http.StatusOK, info)
 }
 
 func (server *server) handleRoomRequest(w http.ResponseWriter, r *http.Request) {
@@ -130,14 +535,33 @@ func (server *server) handleRoomRequest(w http.ResponseWriter, r *http.Request)
 
 	switch r.Method {
 	case http.MethodGet:
-		// status 404 if room doesn't exist; status 200 if it does to let client know they can establish websocket connection
-		_, ok := server.rooms[roomCode]
+		// status 404 if room doesn't exist; status 200 with its configuration if it does to let client know they can establish websocket connection
+		room, ok := server.room(roomCode)
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		t, ok := room.tableState()
 		if !ok {
 			w.WriteHeader(http.StatusNotFound)
-		} else {
-			w.WriteHeader(http.StatusOK)
+			return
 		}
+
+		players, bots := t.seatsTaken()
+		writeJSON(w, http.StatusOK, roomResponse{roomCode, t.Config, players, bots, room.creator})
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	out, err := json.Marshal(v)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
 	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(out)
 }
 
 func (server *server) generateNewRoomCode() string {
@@ -148,7 +572,7 @@ func (server *server) generateNewRoomCode() string {
 			code[i] = characters[rand.Intn(len(characters))]
 		}
 
-		if _, ok := server.rooms[string(code)]; !ok {
+		if _, ok := server.room(string(code)); !ok {
 			break
 		}
 	}
@@ -165,13 +589,38 @@ func (server *server) setMoney(uid string, amount int64) {
 	server.playerLock.Unlock()
 }
 
-func (server *server) deltaMoney(uid string, delta int64) {
+// changes a real wallet, recording the change in the ledger
+func (server *server) deltaMoney(uid string, delta int64, why moneyReason) {
 	server.playerLock.Lock()
+	before := server.players[uid]
+	// an admin taking money away was checked against an earlier balance, and bets may have taken some of it since
+	if why.Kind == string(GrantAdmin) && before+delta < 0 {
+		delta = -before
+	}
 	server.players[uid] += delta
-	go server.firestore.Collection("users").Doc(uid).Set(server.ctx,
-		map[string]interface{}{
-			"money": server.players[uid],
-		})
+	// queued while the lock is held so the ledger has changes in the order they happened, the file is written elsewhere
+	server.ledger.record(ledgerEntry{
+		Time:   time.Now().UTC(),
+		UID:    uid,
+		Actor:  why.Actor,
+		Kind:   why.Kind,
+		Amount: delta,
+		Before: before,
+		After:  server.players[uid],
+		Room:   why.Room,
+		Round:  why.Round,
+		Reason: why.Reason,
+	})
+	server.pendingWrites.Add(1)
+	go func(money int64) {
+		defer server.pendingWrites.Done()
+		start := time.Now()
+		err := server.store.save(server.ctx, uid, money)
+		walletWriteSeconds.Observe(time.Since(start).Seconds())
+		if err != nil {
+			slog.Error("error saving money", "uid", uid, "err", err)
+		}
+	}(server.players[uid])
 	server.playerLock.Unlock()
 }
 
@@ -184,96 +633,180 @@ func (server *server) getMoney(uid string) int64 {
 func (server *server) handleCreateRequest(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodPost:
-		var req createRoomRequest
-		err := json.NewDecoder(r.Body).Decode(&req)
+		if !server.allowAuth(w, r) {
+			return
+		}
+		token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		uid, _, err := server.auth.verify(r.Context(), token)
+		if err != nil {
+			authFailuresCounter.WithLabelValues("create").Inc()
+			writeJSON(w, http.StatusUnauthorized, errorResponse{"invalid token"})
+			return
+		}
+
+		ip, user := ipKey(clientIP(r)), uidKey(uid)
+		if left, ok := server.limits.banned(ip, user); ok {
+			writeRateLimited(w, left, "temporarily banned")
+			return
+		}
+		limits := server.config.Limits
+		// only the address or player that went over the limit gets a strike
+		for _, key := range []string{ip, user} {
+			if !server.limits.allow("create", key, limits.CreateRate, limits.CreateBurst) {
+				server.limits.strike(key)
+				writeRateLimited(w, time.Duration(float64(time.Second)/limits.CreateRate), "too many rooms created")
+				return
+			}
+		}
+
+		if server.draining.Load() {
+			writeJSON(w, http.StatusServiceUnavailable, errorResponse{"server is shutting down"})
+			return
+		}
+
+		var config tableConfig
+		err = json.NewDecoder(r.Body).Decode(&config)
 		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
+			writeJSON(w, http.StatusBadRequest, errorResponse{err.Error()})
 			return
 		}
 
-		if req.Seats < 2 || req.Seats > 8 {
-			w.WriteHeader(http.StatusBadRequest)
+		err = config.validate()
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, errorResponse{err.Error()})
 			return
 		}
 
-		roomCode := server.generateNewRoomCode()
-		server.addRoom(roomCode, req.Seats)
+		room, status, reason := server.createRoom(uid, config)
+		if room == nil {
+			writeJSON(w, status, errorResponse{reason})
+			return
+		}
+		room.log.Info("created room", "uid", uid)
 
-		w.WriteHeader(http.StatusCreated)
-		w.Write([]byte(roomCode))
+		writeJSON(w, http.StatusCreated, roomResponse{Code: room.code, Config: config, Creator: uid})
 	}
 }
 
 func (server *server) handleWebsocketConnections(w http.ResponseWriter, r *http.Request) {
 	// grab requested room from path
 	roomCode := r.PathValue("room")
-	room, ok := server.rooms[roomCode]
+	room, ok := server.room(roomCode)
 	if !ok {
 		return
 	}
 
+	limits := server.config.Limits
+	ip := ipKey(clientIP(r))
+	if !server.allowAuth(w, r) {
+		return
+	}
+	if !server.limits.connect(ip, limits.ConnectionsPerIP) {
+		writeRateLimited(w, time.Second, "too many connections")
+		return
+	}
+	defer server.limits.disconnect(ip)
+
 	// upgrade any connections to a websocket
-	c, err := upgrader.Upgrade(w, r, nil)
+	log := room.log.With("conn", server.connIDs.Add(1), "remote", r.RemoteAddr)
+	c, err := server.upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Println("websocket upgrade error:", err)
+		log.Warn("websocket upgrade error", "err", err)
 		return
 	}
 	defer c.Close()
+	if limits.MaxMessageSize > 0 {
+		c.SetReadLimit(limits.MaxMessageSize)
+	}
 
-	// require jwt token within 5 seconds of connection to authorize user
-	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	// require auth token shortly after connection to authorize user
+	c.SetReadDeadline(time.Now().Add(time.Duration(server.config.AuthTimeout) * time.Millisecond))
 	_, message, err := c.ReadMessage()
 	if err != nil {
-		log.Println("did not recv firebase auth jwt:", err)
+		log.Info("did not receive auth token", "err", err)
 		return
 	}
 
-	// authorize user with firebase & create/grab firestore document
-	client, err := server.firebase.Auth(server.ctx)
+	// authorize user & create/grab their wallet
+	uid, displayName, err := server.auth.verify(server.ctx, string(message))
 	if err != nil {
-		log.Println("error fetching firebase auth client:", err)
+		authFailuresCounter.WithLabelValues("websocket").Inc()
+		log.Warn("error verifying auth token", "err", err)
 		return
 	}
-
-	token, err := client.VerifyIDToken(server.ctx, string(message))
-	if err != nil {
-		log.Println("error verifying auth token:", err)
+	log = log.With("uid", uid)
+	if server.isBanned(uid) {
+		log.Info("refused banned player")
+		c.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "banned"), time.Now().Add(time.Second))
 		return
 	}
-
-	server.firestore.Collection("users").Doc(token.UID).Create(server.ctx,
-		map[string]interface{}{
-			"money": 1000,
-		})
-
-	player, err := server.firestore.Collection("users").Doc(token.UID).Get(server.ctx)
-	if err != nil {
-		log.Println("error fetching user money:", err)
+	if _, ok := server.limits.banned(uidKey(uid)); ok {
+		log.Info("refused temporarily banned player")
+		c.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "temporarily banned"), time.Now().Add(time.Second))
+		return
+	}
+	if !server.limits.connect(uidKey(uid), limits.ConnectionsPerUID) {
+		log.Info("refused connection over the player's limit")
+		c.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "too many connections"), time.Now().Add(time.Second))
 		return
 	}
+	defer server.limits.disconnect(uidKey(uid))
 
-	// grab display name
-	ur, err := client.GetUser(server.ctx, token.UID)
+	money, err := server.store.load(server.ctx, uid, server.config.StartingMoney)
 	if err != nil {
-		log.Println("error fetching user information:", err)
+		log.Error("error fetching money", "err", err)
 		return
 	}
 
 	// track authorized user and notify other players
-	room.clients[c] = token.UID
-	room.playersUpdates <- playersUpdate{room.clients[c], ur.DisplayName, true}
-	server.setMoney(token.UID, player.Data()["money"].(int64))
+	server.setMoney(uid, money)
+	server.dailyBonus(uid)
+	server.startSession(uid)
+	server.boards.setName(uid, displayName)
+	defer server.endSession(uid)
+	if !room.addClient(c, uid) {
+		c.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "room closed"), time.Now().Add(time.Second))
+		return
+	}
 	defer room.removePlayer(c)
+	log.Info("connected")
+	select {
+	case room.playersUpdates <- playersUpdate{uid, displayName, true}:
+	case <-room.drained:
+		return
+	}
 
+	bucket := server.limits.connectionBucket()
 	for {
-		c.SetReadDeadline(time.Now().Add(5 * time.Minute))
+		c.SetReadDeadline(time.Now().Add(time.Duration(server.config.IdleTimeout) * time.Millisecond))
 		_, message, err := c.ReadMessage()
 		if err != nil {
-			log.Println("error reading from websocket:", err)
+			if err == websocket.ErrReadLimit || websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				log.Warn("error reading from websocket", "err", err)
+			}
+			log.Info("disconnected")
 			break
 		}
-		log.Printf("recv: %s", message)
+		log.Debug("received message", "bytes", len(message))
+
+		// messages over the limit are dropped before they cost the table anything
+		if bucket != nil && !bucket.take() || !server.limits.allow("messages", uidKey(uid), limits.PlayerMessageRate, limits.PlayerMessageBurst) {
+			log.Debug("dropped message over rate limit")
+			// only the player, everyone behind a proxy shares its address
+			if server.limits.strike(uidKey(uid)) {
+				// closes this connection too, ending the loop on the next read
+				server.kick(uid, "temporarily banned")
+			}
+			continue
+		}
 
-		room.wsCommands <- wsCommand{message, room.clients[c]}
+		room.debug.commandsWaiting.Add(1)
+		select {
+		case room.wsCommands <- wsCommand{message, uid}:
+		case <-room.drained:
+			room.debug.commandsWaiting.Add(-1)
+			return
+		}
+		room.debug.commandsWaiting.Add(-1)
 	}
 }
@@ -0,0 +1,241 @@
+package game
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// how players get money beyond their starting balance, 0 disables a policy
+type replenishConfig struct {
+	DailyBonus    int64 `json:"dailyBonus"`    // given on the first connection of each day (UTC)
+	RebuyFloor    int64 `json:"rebuyFloor"`    // players below this can top up to it
+	RebuyCooldown int64 `json:"rebuyCooldown"` // time between rebuys
+}
+
+// server configuration, times are in milliseconds
+type Config struct {
+	Addr             string                 `json:"addr"`
+	AllowedOrigins   []string               `json:"allowedOrigins"`
+	StartingMoney    int64                  `json:"startingMoney"`
+	AuthTimeout      int64                  `json:"authTimeout"`      // time a new connection has to send its token
+	IdleTimeout      int64                  `json:"idleTimeout"`      // time a connection can go without sending anything
+	ShutdownTimeout  int64                  `json:"shutdownTimeout"`  // time tables get to finish their round before bets are refunded
+	Auth             string                 `json:"auth"`             // "firebase" or "dev"
+	Store            string                 `json:"store"`            // "firestore" or "memory"
+	Rooms            map[string]tableConfig `json:"rooms"`            // lobby rooms that always exist
+	RoomsPerUser     int                    `json:"roomsPerUser"`     // rooms a player can have open at once, 0 is unlimited
+	MaxRooms         int                    `json:"maxRooms"`         // created rooms open at once across everyone, 0 is unlimited
+	EmptyRoomTimeout int64                  `json:"emptyRoomTimeout"` // time a created room can go without anyone connected before it's closed
+	SnapshotDir      string                 `json:"snapshotDir"`      // where table snapshots are kept, empty disables snapshots
+	SnapshotInterval int64                  `json:"snapshotInterval"`
+	VerifyReplays    bool                   `json:"verifyReplays"` // replay every finished round and log any that come out differently
+	HistoryFile      string                 `json:"historyFile"`   // JSON lines file completed hands are appended to, empty keeps them in memory
+	Replenish        replenishConfig        `json:"replenish"`
+	GrantsFile       string                 `json:"grantsFile"`     // JSON lines file every grant is appended to, empty keeps them in memory
+	AdminToken       string                 `json:"adminToken"`     // bearer token for admin endpoints, empty disables them
+	AdminAuditFile   string                 `json:"adminAuditFile"` // JSON lines file every admin call is appended to, empty keeps them in memory
+	LedgerFile       string                 `json:"ledgerFile"`     // JSON lines file every wallet change is chained onto, empty keeps only the chain's head in memory
+	LedgerKey        string                 `json:"ledgerKey"`      // HMAC key for the ledger chain, empty chains plain SHA-256 hashes
+	CountWatch       countWatchConfig       `json:"countWatch"`
+	Limits           limitsConfig           `json:"limits"`
+	Log              logConfig              `json:"log"`
+}
+
+func DefaultConfig() Config {
+	roomy, another := defaultTableConfig(), defaultTableConfig()
+	another.Seats = 4
+
+	return Config{
+		Addr:             ":8080",
+		StartingMoney:    1000,
+		AuthTimeout:      5000,
+		IdleTimeout:      300000,
+		ShutdownTimeout:  60000,
+		SnapshotInterval: 10000,
+		RoomsPerUser:     3,
+		MaxRooms:         200,
+		EmptyRoomTimeout: 600000,
+		Replenish: replenishConfig{
+			DailyBonus:    100,
+			RebuyFloor:    100,
+			RebuyCooldown: 3600000,
+		},
+		CountWatch: countWatchConfig{
+			Window:    100,
+			MinBets:   30,
+			Threshold: 0.6,
+		},
+		Limits: limitsConfig{
+			MessageRate:        10,
+			MessageBurst:       20,
+			PlayerMessageRate:  20,
+			PlayerMessageBurst: 40,
+			CreateRate:         0.1,
+			CreateBurst:        3,
+			AuthRate:           1,
+			AuthBurst:          10,
+			EVRate:             2,
+			EVBurst:            10,
+			ConnectionsPerUID:  5,
+			ConnectionsPerIP:   20,
+			MaxMessageSize:     4096,
+			Strikes:            20,
+			StrikeWindow:       60000,
+			BanDuration:        600000,
+		},
+		Log: logConfig{
+			Level:  "info",
+			Format: "text",
+		},
+		Auth:  "firebase",
+		Store: "firestore",
+		Rooms: map[string]tableConfig{
+			"roomy":   roomy,
+			"another": another,
+		},
+	}
+}
+
+// builds configuration from defaults, then the JSON file at path (if any), then environment variables
+func LoadConfig(path string) (Config, error) {
+	config := DefaultConfig()
+
+	if path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return config, err
+		}
+		defer f.Close()
+
+		// rooms in the file replace the default lobby rather than adding to it
+		config.Rooms = nil
+		decoder := json.NewDecoder(f)
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&config)
+		if err != nil {
+			return config, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	if v := os.Getenv("BLACKJACK_ADDR"); v != "" {
+		config.Addr = v
+	}
+	if v := os.Getenv("FRONTEND"); v != "" {
+		config.AllowedOrigins = strings.Split(v, ",")
+	}
+	if v := os.Getenv("BLACKJACK_STARTING_MONEY"); v != "" {
+		money, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return config, fmt.Errorf("BLACKJACK_STARTING_MONEY: %w", err)
+		}
+		config.StartingMoney = money
+	}
+	if v := os.Getenv("BLACKJACK_AUTH"); v != "" {
+		config.Auth = v
+	}
+	if v := os.Getenv("BLACKJACK_STORE"); v != "" {
+		config.Store = v
+	}
+	if v := os.Getenv("BLACKJACK_SNAPSHOT_DIR"); v != "" {
+		config.SnapshotDir = v
+	}
+	if v := os.Getenv("BLACKJACK_HISTORY_FILE"); v != "" {
+		config.HistoryFile = v
+	}
+	if v := os.Getenv("BLACKJACK_GRANTS_FILE"); v != "" {
+		config.GrantsFile = v
+	}
+	if v := os.Getenv("BLACKJACK_ADMIN_TOKEN"); v != "" {
+		config.AdminToken = v
+	}
+	if v := os.Getenv("BLACKJACK_ADMIN_AUDIT_FILE"); v != "" {
+		config.AdminAuditFile = v
+	}
+	if v := os.Getenv("BLACKJACK_LEDGER_FILE"); v != "" {
+		config.LedgerFile = v
+	}
+	if v := os.Getenv("BLACKJACK_LEDGER_KEY"); v != "" {
+		config.LedgerKey = v
+	}
+	if v := os.Getenv("BLACKJACK_LOG_LEVEL"); v != "" {
+		config.Log.Level = v
+	}
+	if v := os.Getenv("BLACKJACK_LOG_FORMAT"); v != "" {
+		config.Log.Format = v
+	}
+
+	return config, nil
+}
+
+func (c Config) Validate() error {
+	switch {
+	case c.Addr == "":
+		return errors.New("addr must be set")
+	case c.StartingMoney < 1:
+		return errors.New("startingMoney must be positive")
+	case c.AuthTimeout < 1:
+		return errors.New("authTimeout must be positive")
+	case c.IdleTimeout < 1:
+		return errors.New("idleTimeout must be positive")
+	case c.ShutdownTimeout < 1:
+		return errors.New("shutdownTimeout must be positive")
+	case c.SnapshotInterval < 1000:
+		return errors.New("snapshotInterval must be at least 1000")
+	case c.RoomsPerUser < 0 || c.MaxRooms < 0:
+		return errors.New("roomsPerUser and maxRooms must not be negative")
+	case c.EmptyRoomTimeout < 1000:
+		return errors.New("emptyRoomTimeout must be at least 1000")
+	case c.Replenish.DailyBonus < 0 || c.Replenish.RebuyFloor < 0 || c.Replenish.RebuyCooldown < 0:
+		return errors.New("replenish amounts and cooldown must not be negative")
+	case c.CountWatch.Window < 0:
+		return errors.New("countWatch window must not be negative")
+	case c.CountWatch.Window > 0 && (c.CountWatch.MinBets < 2 || c.CountWatch.MinBets > c.CountWatch.Window):
+		return errors.New("countWatch minBets must be between 2 and window")
+	case c.CountWatch.Window > 0 && (c.CountWatch.Threshold <= 0 || c.CountWatch.Threshold > 1):
+		return errors.New("countWatch threshold must be above 0 and at most 1")
+	case c.Auth != "firebase" && c.Auth != "dev":
+		return errors.New("auth must be firebase or dev")
+	case c.Store != "firestore" && c.Store != "memory":
+		return errors.New("store must be firestore or memory")
+	}
+
+	if err := c.Limits.validate(); err != nil {
+		return err
+	}
+	if err := c.Log.validate(); err != nil {
+		return err
+	}
+
+	for _, origin := range c.AllowedOrigins {
+		if origin == "" {
+			return errors.New("allowedOrigins must not contain empty origins")
+		}
+	}
+
+	for code, room := range c.Rooms {
+		if !validRoomCode(code) {
+			return fmt.Errorf("room code %q must be letters, digits, - or _", code)
+		}
+		if err := room.validate(); err != nil {
+			return fmt.Errorf("room %s: %w", code, err)
+		}
+	}
+
+	return nil
+}
+
+func validRoomCode(code string) bool {
+	if code == "" {
+		return false
+	}
+	for _, r := range code {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
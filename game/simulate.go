@@ -0,0 +1,234 @@
+package game
+
+import (
+	"cmp"
+	"errors"
+	"math"
+	"slices"
+	"sync"
+	"time"
+)
+
+// how a simulated player plays its hands
+var simulationStrategies = map[string]func(t *table) move{
+	"basic": func(t *table) move {
+		return t.recommendedMove()
+	},
+	// hits to 17 like the dealer
+	"mimic": func(t *table) move {
+		if t.currentHand().bestScore() < 17 {
+			return Hit
+		}
+		return Stand
+	},
+	// never takes a card that could bust the hand
+	"neverBust": func(t *table) move {
+		h := t.currentHand()
+		if h.bestScore() < 12 || h.isSoft() && h.bestScore() < 18 {
+			return Hit
+		}
+		return Stand
+	},
+}
+
+// settings for an offline run of a single player at a table
+type SimulationConfig struct {
+	Table    tableConfig `json:"table"`    // rules, decks, penetration and minimum bet
+	Rounds   int64       `json:"rounds"`   // rounds played in total across workers
+	Workers  int         `json:"workers"`  // tables played in parallel
+	Strategy string      `json:"strategy"` // basic, mimic or neverBust
+	Spread   []int64     `json:"spread"`   // bet in minimum bets by Hi-Lo true count from 0 up, the last entry covering higher counts
+	Bankroll int64       `json:"bankroll"` // in minimum bets, for risk of ruin
+	Session  int64       `json:"session"`  // rounds in a session, for how often the bankroll is lost within one
+}
+
+func (c SimulationConfig) validate() error {
+	switch {
+	case c.Rounds < 1:
+		return errors.New("rounds must be positive")
+	case c.Workers < 1:
+		return errors.New("workers must be positive")
+	case simulationStrategies[c.Strategy] == nil:
+		return errors.New("strategy must be basic, mimic or neverBust")
+	case len(c.Spread) == 0:
+		return errors.New("spread must have at least one entry")
+	case slices.Min(c.Spread) < 1:
+		return errors.New("spread entries must be positive")
+	case c.Bankroll < 1:
+		return errors.New("bankroll must be positive")
+	case c.Session < 1:
+		return errors.New("session must be positive")
+	}
+	return c.Table.validate()
+}
+
+// how often a round ended with a given net result
+type outcomeCount struct {
+	Net   float64 `json:"net"` // in minimum bets
+	Count int64   `json:"count"`
+	Share float64 `json:"share"`
+}
+
+// results of a simulation, money is in minimum bets
+type SimulationResult struct {
+	Config           SimulationConfig     `json:"config"`
+	Rounds           int64                `json:"rounds"`
+	Hands            int64                `json:"hands"`   // including hands from splits
+	Wagered          float64              `json:"wagered"` // initial bets only
+	Net              float64              `json:"net"`
+	HouseEdge        float64              `json:"houseEdge"`      // share of initial bets the house keeps
+	HouseEdgeError   float64              `json:"houseEdgeError"` // standard error of the house edge
+	MeanPerRound     float64              `json:"meanPerRound"`
+	VariancePerRound float64              `json:"variancePerRound"`
+	StdDevPerRound   float64              `json:"stdDevPerRound"`
+	Results          map[handResult]int64 `json:"results"`
+	Distribution     []outcomeCount       `json:"distribution"` // net result of each round
+	RiskOfRuin       float64              `json:"riskOfRuin"`   // chance of ever losing the bankroll, from the mean and variance
+	Sessions         int64                `json:"sessions"`
+	SessionRuin      float64              `json:"sessionRuin"` // share of sessions that lost the bankroll
+	Elapsed          int64                `json:"elapsed"`     // milliseconds
+}
+
+// running totals for one worker, in money
+type simulationTotals struct {
+	rounds, hands, wagered, sessions, ruined int64
+	net, sumSquares                          float64
+	results                                  map[handResult]int64
+	outcomes                                 map[int64]int64
+}
+
+func (s *simulationTotals) merge(o simulationTotals) {
+	s.rounds += o.rounds
+	s.hands += o.hands
+	s.wagered += o.wagered
+	s.sessions += o.sessions
+	s.ruined += o.ruined
+	s.net += o.net
+	s.sumSquares += o.sumSquares
+	for k, v := range o.results {
+		s.results[k] += v
+	}
+	for k, v := range o.outcomes {
+		s.outcomes[k] += v
+	}
+}
+
+const simulationUID = "sim"
+
+// plays rounds of a single seat through the table's own logic
+func simulateRounds(c SimulationConfig, rounds int64) simulationTotals {
+	totals := simulationTotals{results: make(map[handResult]int64), outcomes: make(map[int64]int64)}
+
+	// the table never runs out of money, the bankroll is tracked separately for sessions
+	money := int64(math.MaxInt64 / 2)
+	t := newTable(nil, c.Table, func(string) int64 { return money }, func(_ string, delta int64, _ moneyReason) { money += delta })
+	t.headless = true
+	t.handSettled = func(_ string, o handOutcome) {
+		totals.hands++
+		totals.results[o.Result]++
+	}
+	strategy := simulationStrategies[c.Strategy]
+
+	t.apply(roundEvent{Type: EventConnect, UID: simulationUID, DisplayName: simulationUID})
+	t.apply(botCommand(simulationUID, playerCommand{Action: "join", Seat: 0}))
+
+	bankroll, ruined := c.Bankroll*c.Table.MinBet, false
+	for i := range rounds {
+		if i%c.Session == 0 {
+			totals.sessions++
+			bankroll, ruined = c.Bankroll*c.Table.MinBet, false
+		}
+
+		index := min(max(int(math.Floor(t.trueCount())), 0), len(c.Spread)-1)
+		bet := c.Spread[index] * c.Table.MinBet
+		if c.Table.MaxBet > 0 {
+			bet = min(bet, c.Table.MaxBet)
+		}
+
+		before := money
+		t.apply(botCommand(simulationUID, playerCommand{Action: "bet", Seat: 0, Bet: bet}))
+		for t.status == PlayerTurn {
+			t.apply(botCommand(simulationUID, playerCommand{Action: string(strategy(&t))}))
+		}
+		net := money - before
+
+		totals.rounds++
+		totals.wagered += bet
+		totals.net += float64(net)
+		totals.sumSquares += float64(net) * float64(net)
+		totals.outcomes[net]++
+
+		bankroll += net
+		if bankroll <= 0 && !ruined {
+			totals.ruined++
+			ruined = true
+		}
+	}
+	return totals
+}
+
+// plays the configured number of rounds across workers and sums up the results
+func Simulate(c SimulationConfig) (SimulationResult, error) {
+	err := c.validate()
+	if err != nil {
+		return SimulationResult{}, err
+	}
+
+	start := time.Now()
+	totals := simulationTotals{results: make(map[handResult]int64), outcomes: make(map[int64]int64)}
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	for w := range int64(c.Workers) {
+		rounds := c.Rounds / int64(c.Workers)
+		if w < c.Rounds%int64(c.Workers) {
+			rounds++
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			t := simulateRounds(c, rounds)
+			lock.Lock()
+			totals.merge(t)
+			lock.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	unit := float64(c.Table.MinBet)
+	n := float64(totals.rounds)
+	mean := totals.net / n / unit
+	variance := totals.sumSquares/n/(unit*unit) - mean*mean
+	meanBet := float64(totals.wagered) / n / unit
+
+	r := SimulationResult{
+		Config:           c,
+		Rounds:           totals.rounds,
+		Hands:            totals.hands,
+		Wagered:          float64(totals.wagered) / unit,
+		Net:              totals.net / unit,
+		HouseEdge:        -mean / meanBet,
+		HouseEdgeError:   math.Sqrt(variance/n) / meanBet,
+		MeanPerRound:     mean,
+		VariancePerRound: variance,
+		StdDevPerRound:   math.Sqrt(variance),
+		Results:          totals.results,
+		Sessions:         totals.sessions,
+		SessionRuin:      float64(totals.ruined) / float64(totals.sessions),
+		Elapsed:          time.Since(start).Milliseconds(),
+	}
+
+	// a player with an edge loses everything with this chance, one without always does eventually
+	r.RiskOfRuin = 1
+	if mean > 0 {
+		r.RiskOfRuin = math.Exp(-2 * mean * float64(c.Bankroll) / variance)
+	}
+
+	for net, count := range totals.outcomes {
+		r.Distribution = append(r.Distribution, outcomeCount{float64(net) / unit, count, float64(count) / n})
+	}
+	slices.SortFunc(r.Distribution, func(a, b outcomeCount) int {
+		return cmp.Compare(a.Net, b.Net)
+	})
+	return r, nil
+}
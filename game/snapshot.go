@@ -0,0 +1,219 @@
+package game
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// storage for table snapshots, keyed by room code
+type snapshotStore interface {
+	save(code string, data []byte) error
+	loadAll() (map[string][]byte, error)
+	remove(code string) error
+}
+
+// keeps one JSON file per room in a local directory
+type fileSnapshotStore struct {
+	dir string
+}
+
+func newFileSnapshotStore(dir string) (*fileSnapshotStore, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+	return &fileSnapshotStore{dir}, nil
+}
+
+func (s *fileSnapshotStore) save(code string, data []byte) error {
+	// write to a temporary file first so a crash mid-write never leaves a broken snapshot
+	tmp := filepath.Join(s.dir, code+".json.tmp")
+	err := os.WriteFile(tmp, data, 0o644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(s.dir, code+".json"))
+}
+
+func (s *fileSnapshotStore) loadAll() (map[string][]byte, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshots := make(map[string][]byte)
+	for _, e := range entries {
+		code, ok := strings.CutSuffix(e.Name(), ".json")
+		if !ok || e.IsDir() {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(s.dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		snapshots[code] = data
+	}
+	return snapshots, nil
+}
+
+func (s *fileSnapshotStore) remove(code string) error {
+	err := os.Remove(filepath.Join(s.dir, code+".json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+type handSnapshot struct {
+	Hand
+	MissedRounds      int   `json:"missedRounds"`
+	AutoBetStopLosses int   `json:"autoBetStopLosses"`
+	AutoBetFloor      int64 `json:"autoBetFloor"`
+	LossStreak        int   `json:"lossStreak"`
+	Net               int64 `json:"net"`
+	Doubled           bool  `json:"doubled"`
+	FromSplit         bool  `json:"fromSplit"`
+}
+
+type playerSnapshot struct {
+	UID         string         `json:"id"`
+	DisplayName string         `json:"displayName"`
+	TimeBank    int64          `json:"timeBank"` // milliseconds
+	Active      bool           `json:"active"`
+	Training    trainingReport `json:"training"`
+	Flagged     bool           `json:"flagged"`
+}
+
+// everything needed to bring a table back in the same phase, times are in milliseconds
+type tableSnapshot struct {
+	Taken           time.Time        `json:"taken"`
+	Config          tableConfig      `json:"config"`
+	Shoe            []card           `json:"shoe"`
+	ShoeIndex       int              `json:"shoeIndex"`
+	Dealer          []card           `json:"dealer"`
+	Status          tableStatus      `json:"status"`
+	Players         []playerSnapshot `json:"players"`
+	Hands           []handSnapshot   `json:"hands"`
+	ActiveHand      int              `json:"activeHand"`
+	ActionTimeStart time.Time        `json:"actionTimeStart"`
+	TimeBankInUse   int64            `json:"timeBankInUse"`
+	Creator         string           `json:"creator,omitempty"`   // kept by the room, not the table
+	Lobby           bool             `json:"lobby,omitempty"`     // likewise
+	PlayMoney       map[string]int64 `json:"playMoney,omitempty"` // training tables: each player's play money
+}
+
+// seats held by players and by bots
+func (s tableSnapshot) seatsTaken() (int, int) {
+	players, bots := 0, 0
+	for _, h := range s.Hands {
+		switch {
+		case h.PlayerUID == "":
+		case isBot(h.PlayerUID):
+			bots++
+		default:
+			players++
+		}
+	}
+	return players, bots
+}
+
+func (t *table) snapshot() tableSnapshot {
+	s := tableSnapshot{
+		Taken:           time.Now(),
+		Config:          t.config(),
+		Shoe:            t.deck.cards,
+		ShoeIndex:       t.deck.index,
+		Dealer:          t.dealer.Cards,
+		Status:          t.status,
+		ActiveHand:      t.ActiveHand,
+		ActionTimeStart: t.actionTimeStart,
+		TimeBankInUse:   t.timeBankInUse.Milliseconds(),
+	}
+
+	for _, p := range t.Players {
+		s.Players = append(s.Players, playerSnapshot{p.UID, p.DisplayName, p.timeBank.Milliseconds(), p.active, p.training, p.flagged})
+		if t.training && !p.Bot {
+			if s.PlayMoney == nil {
+				s.PlayMoney = make(map[string]int64)
+			}
+			s.PlayMoney[p.UID] = t.getMoney(p.UID)
+		}
+	}
+
+	for _, h := range t.Hands {
+		s.Hands = append(s.Hands, handSnapshot{h, h.missedRounds, h.autoBetStopLosses, h.autoBetFloor, h.lossStreak, h.net, h.doubled, h.fromSplit})
+	}
+
+	return s
+}
+
+// rebuilds a table from a snapshot; timers resume with the time they had left when the snapshot was taken
+func restoreTable(s tableSnapshot, broadcast chan []byte, getMoney func(string) int64, deltaMoney func(string, int64, moneyReason)) table {
+	t := newTable(broadcast, s.Config, getMoney, deltaMoney)
+
+	t.deck = Deck{cards: s.Shoe, index: s.ShoeIndex}
+	t.dealer = Hand{Cards: s.Dealer}
+	t.status = s.Status
+	t.ActiveHand = s.ActiveHand
+	t.actionTimeStart = s.ActionTimeStart.Add(time.Since(s.Taken))
+	t.timeBankInUse = time.Duration(s.TimeBankInUse) * time.Millisecond
+
+	for _, p := range s.Players {
+		t.Players = append(t.Players, player{UID: p.UID, DisplayName: p.DisplayName, Bot: isBot(p.UID), active: p.Active, timeBank: time.Duration(p.TimeBank) * time.Millisecond, training: p.Training, flagged: p.Flagged})
+	}
+
+	t.Hands = nil
+	for _, h := range s.Hands {
+		hand := h.Hand
+		hand.missedRounds = h.MissedRounds
+		hand.autoBetStopLosses = h.AutoBetStopLosses
+		hand.autoBetFloor = h.AutoBetFloor
+		hand.lossStreak = h.LossStreak
+		hand.net = h.Net
+		hand.doubled = h.Doubled
+		hand.fromSplit = h.FromSplit
+		t.Hands = append(t.Hands, hand)
+	}
+
+	return t
+}
+
+func (s tableSnapshot) validate() error {
+	switch {
+	case len(s.Shoe) != 52*s.Config.Decks || s.ShoeIndex < 0 || s.ShoeIndex > len(s.Shoe):
+		return errors.New("shoe does not match deck count")
+	case len(s.Hands) < s.Config.Seats:
+		return errors.New("fewer hands than seats")
+	case s.Status != Betting && s.Status != PlayerTurn:
+		return errors.New("table was not between actions")
+	case s.Status == PlayerTurn && (s.ActiveHand < 0 || s.ActiveHand >= len(s.Hands) || len(s.Dealer) < 2):
+		return errors.New("active hand out of range")
+	}
+	return s.Config.validate()
+}
+
+// saves the table's current state if snapshots are enabled
+func (room *room) saveSnapshot() {
+	if room.snapshots == nil {
+		return
+	}
+
+	s := room.table.snapshot()
+	s.Creator = room.creator
+	s.Lobby = room.lobby
+	data, err := json.Marshal(s)
+	if err != nil {
+		room.roundLog().Error("error encoding snapshot", "err", err)
+		return
+	}
+
+	err = room.snapshots.save(room.code, data)
+	if err != nil {
+		room.roundLog().Error("error saving snapshot", "err", err)
+	}
+}
@@ -0,0 +1,244 @@
+package game
+
+import (
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// how a hand ended, reported by the table whenever it settles one
+type handOutcome struct {
+	Result  handResult
+	Net     int64
+	Doubled bool
+	Split   bool
+	Time    time.Time
+}
+
+// the few stats shown next to a player at the table
+type statsSummary struct {
+	Hands      int   `json:"hands"`
+	Wins       int   `json:"wins"` // including blackjacks
+	Blackjacks int   `json:"blackjacks"`
+	Net        int64 `json:"net"`
+}
+
+func (s *statsSummary) add(o handOutcome) {
+	s.Hands++
+	s.Net += o.Net
+	switch o.Result {
+	case ResultWin:
+		s.Wins++
+	case ResultBlackjack:
+		s.Wins++
+		s.Blackjacks++
+	}
+}
+
+type dailyNet struct {
+	Day string `json:"day"` // YYYY-MM-DD in UTC
+	Net int64  `json:"net"` // net for the day
+}
+
+// lifetime stats of a player across every room
+type playerStats struct {
+	statsSummary
+	Losses      int        `json:"losses"` // including busts and surrenders
+	Pushes      int        `json:"pushes"`
+	Busts       int        `json:"busts"`
+	Surrenders  int        `json:"surrenders"`
+	Doubles     int        `json:"doubles"`
+	DoubleWins  int        `json:"doubleWins"`
+	Splits      int        `json:"splits"` // hands played from either half of a split
+	SplitWins   int        `json:"splitWins"`
+	BiggestWin  int64      `json:"biggestWin"`
+	NetByDay    []dailyNet `json:"netByDay"`
+	Sessions    int        `json:"sessions"`
+	SessionTime int64      `json:"sessionTime"` // milliseconds connected, summed over sessions
+	connections int
+	connected   time.Time
+}
+
+func (s *playerStats) add(o handOutcome) {
+	s.statsSummary.add(o)
+
+	won := o.Result == ResultWin || o.Result == ResultBlackjack
+	switch o.Result {
+	case ResultPush:
+		s.Pushes++
+	case ResultLoss:
+		s.Losses++
+	case ResultBust:
+		s.Losses++
+		s.Busts++
+	case ResultSurrender:
+		s.Losses++
+		s.Surrenders++
+	}
+
+	if o.Doubled {
+		s.Doubles++
+		if won {
+			s.DoubleWins++
+		}
+	}
+	if o.Split {
+		s.Splits++
+		if won {
+			s.SplitWins++
+		}
+	}
+	s.BiggestWin = max(s.BiggestWin, o.Net)
+
+	day := o.Time.UTC().Format(time.DateOnly)
+	if n := len(s.NetByDay); n > 0 && s.NetByDay[n-1].Day == day {
+		s.NetByDay[n-1].Net += o.Net
+	} else {
+		s.NetByDay = append(s.NetByDay, dailyNet{day, o.Net})
+	}
+}
+
+type statsResponse struct {
+	playerStats
+	UID           string  `json:"playerId"`
+	WinRate       float64 `json:"winRate"`
+	LossRate      float64 `json:"lossRate"`
+	PushRate      float64 `json:"pushRate"`
+	DoubleWinRate float64 `json:"doubleWinRate"`
+	SplitWinRate  float64 `json:"splitWinRate"`
+}
+
+func rate(n int, of int) float64 {
+	if of == 0 {
+		return 0
+	}
+	return float64(n) / float64(of)
+}
+
+// returns the stats of a player, creating them if needed; statsLock must be held
+func (server *server) statsOf(uid string) *playerStats {
+	s, ok := server.stats[uid]
+	if !ok {
+		s = &playerStats{NetByDay: []dailyNet{}}
+		server.stats[uid] = s
+	}
+	return s
+}
+
+func (server *server) statsSummary(uid string) statsSummary {
+	server.statsLock.Lock()
+	defer server.statsLock.Unlock()
+
+	if s, ok := server.stats[uid]; ok {
+		return s.statsSummary
+	}
+	return statsSummary{}
+}
+
+func (server *server) handSettled(room string, uid string, o handOutcome) {
+	if isBot(uid) {
+		return
+	}
+
+	server.statsLock.Lock()
+	server.statsOf(uid).add(o)
+	server.statsLock.Unlock()
+
+	server.boards.add(room, uid, o)
+}
+
+// rebuilds hand stats, sessions and leaderboards from hand history, oldest hand first
+func (server *server) loadStats() error {
+	records, err := server.history.query(historyFilter{})
+	if err != nil {
+		return err
+	}
+
+	server.statsLock.Lock()
+	defer server.statsLock.Unlock()
+
+	for i := len(records) - 1; i >= 0; i-- {
+		r := records[i]
+		if r.Result != ResultRefunded && r.Result != "" {
+			o := handOutcome{r.Result, r.Net, r.Doubled, r.Split, r.Ended}
+			server.statsOf(r.UID).add(o)
+			server.boards.add("", r.UID, o)
+		}
+	}
+
+	sessions, err := server.history.sessions()
+	if err != nil {
+		return err
+	}
+	for _, session := range sessions {
+		s := server.statsOf(session.UID)
+		s.Sessions++
+		s.SessionTime += session.Ended.Sub(session.Started).Milliseconds()
+	}
+	return nil
+}
+
+// session time counts while a player has at least one connection open
+func (server *server) startSession(uid string) {
+	server.statsLock.Lock()
+	defer server.statsLock.Unlock()
+
+	s := server.statsOf(uid)
+	if s.connections == 0 {
+		s.Sessions++
+		s.connected = time.Now()
+	}
+	s.connections++
+}
+
+func (server *server) endSession(uid string) {
+	server.statsLock.Lock()
+	s := server.statsOf(uid)
+	s.connections--
+	ended := s.connections == 0
+	session := sessionRecord{uid, s.connected, time.Now()}
+	if ended {
+		s.SessionTime += session.Ended.Sub(session.Started).Milliseconds()
+	}
+	server.statsLock.Unlock()
+
+	if ended {
+		err := server.history.addSession(session)
+		if err != nil {
+			slog.Error("error saving session", "uid", uid, "err", err)
+		}
+	}
+}
+
+func (server *server) handlePlayerStatsRequest(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		uid := r.PathValue("uid")
+		if !server.allowRecords(w, r, uid, "stats") {
+			return
+		}
+
+		// unknown players get empty stats without being added
+		s := playerStats{NetByDay: []dailyNet{}}
+		server.statsLock.Lock()
+		if p, ok := server.stats[uid]; ok {
+			s = *p
+			s.NetByDay = slices.Clone(p.NetByDay)
+			if s.connections > 0 {
+				s.SessionTime += time.Since(s.connected).Milliseconds()
+			}
+		}
+		server.statsLock.Unlock()
+
+		writeJSON(w, http.StatusOK, statsResponse{
+			playerStats:   s,
+			UID:           uid,
+			WinRate:       rate(s.Wins, s.Hands),
+			LossRate:      rate(s.Losses, s.Hands),
+			PushRate:      rate(s.Pushes, s.Hands),
+			DoubleWinRate: rate(s.DoubleWins, s.Doubles),
+			SplitWinRate:  rate(s.SplitWins, s.Splits),
+		})
+	}
+}
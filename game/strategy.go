@@ -0,0 +1,160 @@
+package game
+
+type move string
+
+const (
+	Hit       move = "hit"
+	Stand     move = "stand"
+	Double    move = "double"
+	Split     move = "split"
+	Surrender move = "surrender"
+)
+
+// returns the basic strategy play for a hand against the dealer's up card under the table's rules
+// falls back to the next best play when doubling, splitting or surrendering isn't possible
+func basicStrategy(h Hand, up card, rules tableRules, decks int, canDouble bool, canSplit bool, canSurrender bool) move {
+	d := up.value()
+	if up.Rank == Ace {
+		d = 11
+	}
+
+	firstTwo := len(h.Cards) == 2
+	canDouble = canDouble && firstTwo
+	canSurrender = canSurrender && firstTwo
+	h17, das, fewDecks := rules.DealerHitsSoft17, rules.DoubleAfterSplit, decks <= 2
+
+	// pairs
+	if canSplit && firstTwo && h.Cards[0].value() == h.Cards[1].value() {
+		switch h.Cards[0].value() {
+		case 1:
+			return Split
+		case 8:
+			if h17 && d == 11 && canSurrender {
+				return Surrender
+			}
+			return Split
+		case 9:
+			if d != 7 && d != 10 && d != 11 {
+				return Split
+			}
+		case 7:
+			if d <= 7 {
+				return Split
+			}
+		case 6:
+			if d <= 6 && (das || d >= 3) {
+				return Split
+			}
+		case 4:
+			if das && (d == 5 || d == 6) {
+				return Split
+			}
+		case 3, 2:
+			if d <= 7 && (das || d >= 4) {
+				return Split
+			}
+		}
+	}
+
+	hard, soft := 0, false
+	for _, c := range h.Cards {
+		hard += c.value()
+		if c.Rank == Ace {
+			soft = true
+		}
+	}
+	soft = soft && hard+10 <= 21
+
+	if soft {
+		total := hard + 10
+		switch {
+		case total >= 20:
+			return Stand
+		case total == 19:
+			if h17 && d == 6 {
+				return doubleOr(canDouble, Stand)
+			}
+			return Stand
+		case total == 18:
+			if d >= 3 && d <= 6 || h17 && d == 2 {
+				return doubleOr(canDouble, Stand)
+			}
+			if d <= 8 {
+				return Stand
+			}
+			return Hit
+		case total == 17:
+			if d >= 3 && d <= 6 {
+				return doubleOr(canDouble, Hit)
+			}
+		case total >= 15:
+			if d >= 4 && d <= 6 {
+				return doubleOr(canDouble, Hit)
+			}
+		case total >= 13:
+			if d == 5 || d == 6 {
+				return doubleOr(canDouble, Hit)
+			}
+		}
+		return Hit
+	}
+
+	switch {
+	case hard >= 17:
+		if hard == 17 && h17 && d == 11 && canSurrender {
+			return Surrender
+		}
+		return Stand
+	case hard == 16 && d >= 9, hard == 15 && (d == 10 || h17 && d == 11):
+		if canSurrender {
+			return Surrender
+		}
+		return Hit
+	case hard >= 13:
+		if d <= 6 {
+			return Stand
+		}
+	case hard == 12:
+		if d >= 4 && d <= 6 {
+			return Stand
+		}
+	case hard == 11:
+		if d <= 10 || h17 || fewDecks {
+			return doubleOr(canDouble, Hit)
+		}
+	case hard == 10:
+		if d <= 9 {
+			return doubleOr(canDouble, Hit)
+		}
+	case hard == 9:
+		if d >= 3 && d <= 6 || fewDecks && d == 2 {
+			return doubleOr(canDouble, Hit)
+		}
+	case hard == 8:
+		if decks == 1 && (d == 5 || d == 6) {
+			return doubleOr(canDouble, Hit)
+		}
+	}
+	return Hit
+}
+
+func doubleOr(canDouble bool, fallback move) move {
+	if canDouble {
+		return Double
+	}
+	return fallback
+}
+
+// the basic strategy play for the active hand
+func (t *table) recommendedMove() move {
+	return basicStrategy(*t.currentHand(), t.dealer.Cards[0], t.rules, t.decks, t.canDouble(), t.canSplit(), t.canSurrender())
+}
+
+// privately tells the active player the basic strategy play for their hand
+func (t *table) hint(uid string) {
+	if !t.hints {
+		return
+	}
+
+	t.tell(uid, privateMessage{Hint: &hintMessage{t.ActiveHand, t.recommendedMove()}})
+}
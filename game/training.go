@@ -0,0 +1,102 @@
+package game
+
+import "math"
+
+type gradeMessage struct {
+	Hand        int     `json:"hand"`
+	Action      move    `json:"action"`
+	Recommended move    `json:"recommended"`
+	Correct     bool    `json:"correct"`
+	EVCost      float64 `json:"evCost"` // expected money lost by the play compared to basic strategy, for the cards left in the shoe
+}
+
+// how closely a player followed basic strategy while at a training table
+type trainingReport struct {
+	Decisions int     `json:"decisions"`
+	Correct   int     `json:"correct"`
+	Accuracy  float64 `json:"accuracy"`
+	EVLost    float64 `json:"evLost"`
+}
+
+// grades a play for the active hand against basic strategy, before it is made
+func (t *table) gradeMove(m move) gradeMessage {
+	h := t.currentHand()
+	recommended := t.recommendedMove()
+	g := gradeMessage{Hand: t.ActiveHand, Action: m, Recommended: recommended, Correct: m == recommended}
+
+	if !g.Correct {
+		values := moveValues(*h, t.dealer.Cards[0], t.unseenCards(), t.rules, t.canDouble(), t.canSplit(), t.canSurrender())
+		if v, ok := values[m]; ok {
+			g.EVCost = math.Max(0, values[recommended]-v) * float64(h.Bet)
+			g.EVCost = math.Round(g.EVCost*100) / 100
+		}
+	}
+	return g
+}
+
+// adds a grade to the player's report and tells them privately
+func (t *table) sendGrade(uid string, g gradeMessage) {
+	p := t.playerWithUID(uid)
+	if p == nil {
+		return
+	}
+
+	p.training.Decisions++
+	if g.Correct {
+		p.training.Correct++
+	}
+	p.training.EVLost += g.EVCost
+	t.tell(uid, privateMessage{Grade: &g})
+}
+
+// a player's report for this session at the table, false outside training tables
+func (t *table) report(uid string) (trainingReport, bool) {
+	p := t.playerWithUID(uid)
+	if p == nil || !t.training {
+		return trainingReport{}, false
+	}
+
+	r := p.training
+	if r.Decisions > 0 {
+		r.Accuracy = float64(r.Correct) / float64(r.Decisions)
+	}
+	r.EVLost = math.Round(r.EVLost*100) / 100
+	return r, true
+}
+
+// privately sends a player their report for this session at the table
+func (t *table) sendReport(uid string) {
+	if r, ok := t.report(uid); ok {
+		t.tell(uid, privateMessage{Report: &r})
+	}
+}
+
+// sends the report for a session that is over and starts the player on a new one
+func (t *table) endSession(uid string) {
+	if r, ok := t.report(uid); ok && r.Decisions > 0 {
+		t.tell(uid, privateMessage{Report: &r})
+		t.playerWithUID(uid).training = trainingReport{}
+	}
+}
+
+// play money for training tables, kept apart from real wallets; only used from the table's goroutine
+type playMoney struct {
+	starting int64
+	money    map[string]int64
+}
+
+func newPlayMoney(starting int64) *playMoney {
+	return &playMoney{starting, make(map[string]int64)}
+}
+
+func (m *playMoney) get(uid string) int64 {
+	money, ok := m.money[uid]
+	if !ok {
+		return m.starting
+	}
+	return money
+}
+
+func (m *playMoney) delta(uid string, delta int64) {
+	m.money[uid] = m.get(uid) + delta
+}
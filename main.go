@@ -1,9 +1,65 @@
 package main
 
 import (
+	"flag"
+	"log"
+	"os"
+	"strings"
+
 	"github.com/xalbd/blackjack-server/game"
 )
 
 func main() {
-	game.StartServer()
+	configPath := flag.String("config", os.Getenv("BLACKJACK_CONFIG"), "path to JSON config file")
+	addr := flag.String("addr", "", "address to listen on")
+	origins := flag.String("origins", "", "comma separated list of allowed origins")
+	startingMoney := flag.Int64("starting-money", 0, "money given to new players")
+	auth := flag.String("auth", "", "auth provider (firebase or dev)")
+	store := flag.String("store", "", "wallet store (firestore or memory)")
+	snapshotDir := flag.String("snapshot-dir", "", "directory for table snapshots")
+	historyFile := flag.String("history-file", "", "file completed hands are appended to")
+	logLevel := flag.String("log-level", "", "minimum level logged (debug, info, warn or error)")
+	logFormat := flag.String("log-format", "", "log output format (text or json)")
+	flag.Parse()
+
+	config, err := game.LoadConfig(*configPath)
+	if err != nil {
+		log.Fatalln("error loading config:", err)
+	}
+
+	// flags override the config file and environment
+	if *addr != "" {
+		config.Addr = *addr
+	}
+	if *origins != "" {
+		config.AllowedOrigins = strings.Split(*origins, ",")
+	}
+	if *startingMoney != 0 {
+		config.StartingMoney = *startingMoney
+	}
+	if *auth != "" {
+		config.Auth = *auth
+	}
+	if *store != "" {
+		config.Store = *store
+	}
+	if *snapshotDir != "" {
+		config.SnapshotDir = *snapshotDir
+	}
+	if *historyFile != "" {
+		config.HistoryFile = *historyFile
+	}
+	if *logLevel != "" {
+		config.Log.Level = *logLevel
+	}
+	if *logFormat != "" {
+		config.Log.Format = *logFormat
+	}
+
+	err = config.Validate()
+	if err != nil {
+		log.Fatalln("invalid config:", err)
+	}
+
+	game.StartServer(config)
 }